@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/src-d/proteus/lint"
+	"github.com/src-d/proteus/report"
+)
+
+// runLint scans and resolves the given packages without generating anything
+// and fails if any of them has something that can not be converted to
+// protobuf. Warnings reported while scanning and resolving, such as fields
+// with unsupported types, count as problems as well.
+func runLint(args []string) int {
+	fs, opts := newFlagSet("lint")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if err := opts.setup(); err != nil {
+		report.Error("%s", err)
+		return exitUsage
+	}
+
+	stop, err := opts.profile()
+	if err != nil {
+		report.Error("unable to start profiling: %s", err)
+		return exitUsage
+	}
+	defer stop()
+
+	s := new(summary)
+	s.countReports()
+	return s.finish(opts, lintPackages(opts, s))
+}
+
+func lintPackages(opts *options, s *summary) int {
+	pkgs, err := opts.load()
+	if err != nil {
+		report.Error("%s", err)
+		return loadExitCode(err)
+	}
+	s.countPackages(pkgs)
+
+	for _, p := range lint.Check(pkgs) {
+		fields := report.Fields{"package": p.Package}
+		if p.Struct != "" {
+			fields["struct"] = p.Struct
+		}
+		if p.Field != "" {
+			fields["field"] = p.Field
+		}
+		if p.Pos.IsValid() {
+			fields["pos"] = p.Pos.String()
+		}
+		report.With(fields).Error("%s", p.Message)
+	}
+
+	if n := atomic.LoadInt32(&s.Warnings) + atomic.LoadInt32(&s.Errors); n > 0 {
+		report.Info("found %d problem(s)", n)
+		return exitProblems
+	}
+
+	report.Info("no problems found")
+	return exitOK
+}
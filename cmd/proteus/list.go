@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/src-d/proteus/report"
+	"github.com/src-d/proteus/resolver"
+	"github.com/src-d/proteus/scanner"
+)
+
+// runList prints the messages and enums found in the given packages along
+// with the types their fields are resolved to, so it can be checked why a
+// type or a field was or was not picked up.
+func runList(args []string) int {
+	fs, opts := newFlagSet("list")
+	asJSON := fs.Bool("json", false, "print the packages as JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if err := opts.setup(); err != nil {
+		report.Error("%s", err)
+		return exitUsage
+	}
+
+	stop, err := opts.profile()
+	if err != nil {
+		report.Error("unable to start profiling: %s", err)
+		return exitUsage
+	}
+	defer stop()
+
+	s := new(summary)
+	s.countReports()
+	return s.finish(opts, listPackages(os.Stdout, opts, *asJSON, s))
+}
+
+// listPackages loads the packages and writes their list to w.
+func listPackages(w io.Writer, opts *options, asJSON bool, s *summary) int {
+	pkgs, err := opts.load()
+	if err != nil {
+		report.Error("%s", err)
+		return loadExitCode(err)
+	}
+	s.countPackages(pkgs)
+
+	list := newPackageList(pkgs)
+	if asJSON {
+		err = json.NewEncoder(w).Encode(list)
+	} else {
+		err = printPackageList(w, list)
+	}
+
+	if err != nil {
+		report.Error("unable to print packages: %s", err)
+		return exitIOError
+	}
+	return exitOK
+}
+
+type listedPackage struct {
+	Path         string           `json:"path"`
+	Name         string           `json:"name"`
+	ProtoPackage string           `json:"proto_package,omitempty"`
+	Messages     []*listedMessage `json:"messages"`
+	Enums        []*listedEnum    `json:"enums"`
+}
+
+type listedMessage struct {
+	Name    string         `json:"name"`
+	Fields  []*listedField `json:"fields"`
+	Omitted []string       `json:"omitted,omitempty"`
+}
+
+type listedField struct {
+	Name      string      `json:"name"`
+	Type      *listedType `json:"type"`
+	ProtoType string      `json:"proto_type,omitempty"`
+}
+
+type listedEnum struct {
+	Name       string             `json:"name"`
+	Underlying string             `json:"underlying,omitempty"`
+	Values     []*listedEnumValue `json:"values"`
+}
+
+type listedEnumValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type listedType struct {
+	Kind     string      `json:"kind"`
+	Package  string      `json:"package,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Key      *listedType `json:"key,omitempty"`
+	Value    *listedType `json:"value,omitempty"`
+	Repeated bool        `json:"repeated"`
+	Nullable bool        `json:"nullable"`
+}
+
+func (t *listedType) String() string {
+	if t == nil {
+		return "<unresolved>"
+	}
+
+	var name string
+	switch t.Kind {
+	case "named":
+		name = fmt.Sprintf("%s.%s", t.Package, t.Name)
+	case "map":
+		name = fmt.Sprintf("map[%s]%s", t.Key, t.Value)
+	default:
+		name = t.Name
+	}
+
+	if t.Repeated {
+		return "[]" + name
+	}
+	return name
+}
+
+func newPackageList(pkgs resolver.Packages) []*listedPackage {
+	var list = make([]*listedPackage, 0, len(pkgs))
+	for _, p := range pkgs {
+		lp := &listedPackage{
+			Path:         p.Path,
+			Name:         p.Name,
+			ProtoPackage: p.ProtoPackage,
+			Messages:     make([]*listedMessage, 0, len(p.Structs)),
+			Enums:        make([]*listedEnum, 0, len(p.Enums)),
+		}
+
+		for _, s := range p.Structs {
+			m := &listedMessage{
+				Name:    s.Name,
+				Fields:  make([]*listedField, 0, len(s.Fields)),
+				Omitted: s.Omitted,
+			}
+			for _, f := range s.Fields {
+				m.Fields = append(m.Fields, &listedField{f.Name, newListedType(f.Type), f.ProtoType})
+			}
+			lp.Messages = append(lp.Messages, m)
+		}
+
+		for _, e := range p.Enums {
+			le := &listedEnum{
+				Name:       e.Name,
+				Underlying: e.Underlying,
+				Values:     make([]*listedEnumValue, 0, len(e.Values)),
+			}
+			for _, v := range e.Values {
+				le.Values = append(le.Values, &listedEnumValue{v.Name, v.Value.ExactString()})
+			}
+			lp.Enums = append(lp.Enums, le)
+		}
+
+		list = append(list, lp)
+	}
+	return list
+}
+
+func newListedType(typ scanner.Type) *listedType {
+	if typ == nil {
+		return nil
+	}
+
+	t := &listedType{
+		Repeated: typ.IsRepeated(),
+		Nullable: typ.IsNullable(),
+	}
+
+	switch typ := typ.(type) {
+	case *scanner.Basic:
+		t.Kind = "basic"
+		t.Name = typ.Name
+	case *scanner.Named:
+		t.Kind = "named"
+		t.Package = typ.Path
+		t.Name = typ.Name
+	case *scanner.Map:
+		t.Kind = "map"
+		t.Key = newListedType(typ.Key)
+		t.Value = newListedType(typ.Value)
+	}
+	return t
+}
+
+func printPackageList(w io.Writer, list []*listedPackage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, p := range list {
+		fmt.Fprintf(tw, "package %s (%s)\n", p.Name, p.Path)
+		if p.ProtoPackage != "" {
+			fmt.Fprintf(tw, "  proto package %s\n", p.ProtoPackage)
+		}
+		for _, m := range p.Messages {
+			fmt.Fprintf(tw, "  message %s\n", m.Name)
+			for _, f := range m.Fields {
+				if f.ProtoType != "" {
+					fmt.Fprintf(tw, "    %s\t%s\tas %s\n", f.Name, f.Type, f.ProtoType)
+				} else {
+					fmt.Fprintf(tw, "    %s\t%s\n", f.Name, f.Type)
+				}
+			}
+			for _, name := range m.Omitted {
+				fmt.Fprintf(tw, "    %s\t<omitted>\n", name)
+			}
+		}
+
+		for _, e := range p.Enums {
+			if e.Underlying != "" {
+				fmt.Fprintf(tw, "  enum %s (%s)\n", e.Name, e.Underlying)
+			} else {
+				fmt.Fprintf(tw, "  enum %s\n", e.Name)
+			}
+			for _, v := range e.Values {
+				fmt.Fprintf(tw, "    %s\t= %s\n", v.Name, v.Value)
+			}
+		}
+	}
+	return tw.Flush()
+}
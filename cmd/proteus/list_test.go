@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/constant"
+	"testing"
+
+	"github.com/src-d/proteus/resolver"
+	"github.com/src-d/proteus/scanner"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListedTypeString(t *testing.T) {
+	cases := []struct {
+		typ      scanner.Type
+		expected string
+	}{
+		{scanner.NewBasic("int"), "int"},
+		{repeated(scanner.NewBasic("int")), "[]int"},
+		{scanner.NewNamed("time", "Time"), "time.Time"},
+		{
+			scanner.NewMap(scanner.NewBasic("string"), repeated(scanner.NewNamed("foo", "Bar"))),
+			"map[string][]foo.Bar",
+		},
+		{scanner.NewMap(scanner.NewBasic("string"), nil), "map[string]<unresolved>"},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, newListedType(c.typ).String())
+	}
+}
+
+func TestPrintPackageList(t *testing.T) {
+	pkgs := resolver.Packages{
+		&scanner.Package{
+			Path:         "/foo",
+			Name:         "foo",
+			ProtoPackage: "acme.foo",
+			Structs: []*scanner.Struct{
+				{
+					Name: "Foo",
+					Fields: []*scanner.Field{
+						{Name: "A", Type: scanner.NewBasic("int"), ProtoType: "uint64"},
+						{Name: "Bar", Type: repeated(scanner.NewNamed("/foo", "Bar"))},
+					},
+					Omitted: []string{"Done"},
+				},
+			},
+			Enums: []*scanner.Enum{
+				{
+					Name:       "Bar",
+					Underlying: "string",
+					Values: []*scanner.EnumValue{
+						{Name: "ABar", Value: constant.MakeString("a")},
+						{Name: "BBar", Value: constant.MakeString("b")},
+					},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.Nil(t, printPackageList(&buf, newPackageList(pkgs)))
+
+	expected := `package foo (/foo)
+  proto package acme.foo
+  message Foo
+    A     int  as uint64
+    Bar   []/foo.Bar
+    Done  <omitted>
+  enum Bar (string)
+    ABar  = "a"
+    BBar  = "b"
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestListPackagesWriteError(t *testing.T) {
+	opts := &options{paths: pathList{projectPath("fixtures/subpkg")}, unsupported: "skip"}
+
+	var buf bytes.Buffer
+	require.Equal(t, exitOK, listPackages(&buf, opts, false, new(summary)))
+	require.NotEqual(t, 0, buf.Len())
+
+	require.Equal(t, exitIOError, listPackages(failingWriter{}, opts, false, new(summary)))
+	require.Equal(t, exitIOError, listPackages(failingWriter{}, opts, true, new(summary)))
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func repeated(t scanner.Type) scanner.Type {
+	t.SetRepeated(true)
+	return t
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+
+	"github.com/src-d/proteus"
+	"github.com/src-d/proteus/report"
+	"github.com/src-d/proteus/resolver"
+	"github.com/src-d/proteus/scanner"
+)
+
+// Exit codes of the proteus command. They are part of its interface and
+// will not change between versions, so build systems can rely on them.
+const (
+	// exitOK means the command finished without problems.
+	exitOK = 0
+	// exitProblems means the packages have problems that prevent them from
+	// being converted to protobuf.
+	exitProblems = 1
+	// exitUsage means the command was invoked with invalid arguments.
+	exitUsage = 2
+	// exitScanError means the packages could not be scanned, because they
+	// do not exist or they do not type check.
+	exitScanError = 3
+	// exitIOError means the output of the command could not be written.
+	exitIOError = 4
+)
+
+type command struct {
+	name  string
+	short string
+	run   func(args []string) int
+}
+
+var commands = []*command{
+	{"lint", "check packages for problems converting them to protobuf", runLint},
+	{"list", "print the messages and enums found in packages", runList},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "proteus: unknown command %q\n", name)
+	usage()
+	os.Exit(exitUsage)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: proteus <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `run "proteus <command> -h" to see the flags of a command`)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "exit codes:")
+	fmt.Fprintf(os.Stderr, "  %d  success\n", exitOK)
+	fmt.Fprintf(os.Stderr, "  %d  problems found in the packages\n", exitProblems)
+	fmt.Fprintf(os.Stderr, "  %d  invalid arguments\n", exitUsage)
+	fmt.Fprintf(os.Stderr, "  %d  packages could not be scanned\n", exitScanError)
+	fmt.Fprintf(os.Stderr, "  %d  output could not be written\n", exitIOError)
+}
+
+// pathList is a flag that can be provided several times.
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *pathList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+var unsupportedPolicies = map[string]scanner.UnsupportedPolicy{
+	"skip":  scanner.SkipUnsupported,
+	"error": scanner.RejectUnsupported,
+	"omit":  scanner.OmitUnsupported,
+}
+
+// tagList is a flag with a comma separated list of build tags.
+type tagList []string
+
+func (l *tagList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *tagList) Set(v string) error {
+	*l = nil
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			*l = append(*l, t)
+		}
+	}
+	return nil
+}
+
+// options are the flags shared by all commands.
+type options struct {
+	paths       pathList
+	exclude     pathList
+	quiet       bool
+	verbose     bool
+	logFormat   string
+	summary     string
+	cpuProfile  string
+	memProfile  string
+	progress    bool
+	mapEntries  bool
+	unsupported string
+	tags        tagList
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	opts := new(options)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Var(&opts.paths, "p", "directory of a package to process, can be given multiple times. Glob patterns and a trailing /... to include all packages below a directory are accepted")
+	fs.Var(&opts.exclude, "exclude", "package directories to leave out, can be given multiple times and accepts the same patterns as -p")
+	fs.Var(&opts.tags, "tags", "comma separated list of additional build tags to take into account when choosing the files of the packages")
+	fs.BoolVar(&opts.quiet, "quiet", false, "only report errors")
+	fs.BoolVar(&opts.verbose, "verbose", false, "report debug messages")
+	fs.StringVar(&opts.logFormat, "log-format", "text", "format of the reported messages: text or json")
+	fs.StringVar(&opts.summary, "summary", "", "write a JSON summary of the results to the given file")
+	fs.BoolVar(&opts.mapEntries, "map-entries", false, "convert maps with key types not valid in protobuf to repeated key-value messages instead of ignoring them")
+	fs.StringVar(&opts.unsupported, "unsupported", "skip", "what to do with fields of types that can not be serialized, such as channels and functions: skip them with a warning, error or omit them silently")
+	fs.BoolVar(&opts.progress, "progress", false, "report the progress of the command as it runs")
+	fs.StringVar(&opts.cpuProfile, "cpuprofile", "", "write a CPU profile to the given file")
+	fs.StringVar(&opts.memProfile, "memprofile", "", "write a memory profile to the given file when the command ends")
+	return fs, opts
+}
+
+// setup applies the options to the report package and makes the patterns
+// of the packages to process absolute. They are expanded when the packages
+// are loaded.
+func (o *options) setup() error {
+	switch {
+	case o.quiet:
+		report.SetLevel(report.ErrorLevel)
+	case o.verbose:
+		report.SetLevel(report.DebugLevel)
+	}
+
+	switch o.logFormat {
+	case "text":
+		report.SetFormat(report.TextFormat)
+	case "json":
+		report.SetFormat(report.JSONFormat)
+	default:
+		return fmt.Errorf("invalid log format: %s", o.logFormat)
+	}
+
+	if _, ok := unsupportedPolicies[o.unsupported]; !ok {
+		return fmt.Errorf("invalid policy for unsupported fields: %s", o.unsupported)
+	}
+
+	if len(o.paths) == 0 {
+		return fmt.Errorf("at least one package must be provided with -p")
+	}
+
+	patterns, err := absPaths(o.paths)
+	if err != nil {
+		return err
+	}
+
+	exclude, err := absPaths(o.exclude)
+	if err != nil {
+		return err
+	}
+
+	o.paths, o.exclude = patterns, exclude
+	return nil
+}
+
+// profile starts the profiling requested in the options. The returned
+// function must be called when the command ends to stop it and write the
+// profiles.
+func (o *options) profile() (func(), error) {
+	var cpu *os.File
+	if o.cpuProfile != "" {
+		f, err := os.Create(o.cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		cpu = f
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+
+		if o.memProfile != "" {
+			if err := writeMemProfile(o.memProfile); err != nil {
+				report.Error("unable to write memory profile: %s", err)
+			}
+		}
+	}, nil
+}
+
+func writeMemProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
+
+func absPaths(paths []string) ([]string, error) {
+	var result []string
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, abs)
+	}
+	return result, nil
+}
+
+// load scans and resolves the packages matching the patterns of the
+// options.
+func (o *options) load() (resolver.Packages, error) {
+	opts := proteus.Options{
+		Paths:      o.paths,
+		Exclude:    o.exclude,
+		MapEntries: o.mapEntries,
+		Scanner: scanner.Options{
+			BuildTags:   o.tags,
+			Unsupported: unsupportedPolicies[o.unsupported],
+		},
+	}
+
+	if o.progress {
+		opts.Listener = new(progressReporter).event
+	}
+
+	return proteus.Load(context.Background(), opts)
+}
+
+// loadExitCode returns the exit code for an error loading packages. Fields
+// rejected because of the unsupported types policy are problems of the
+// packages, as long as all the packages could be scanned otherwise.
+func loadExitCode(err error) int {
+	if errors.Is(err, proteus.ErrNoPackages) {
+		return exitUsage
+	}
+
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	for _, err := range errs {
+		if !errors.Is(err, scanner.ErrUnsupported) {
+			return exitScanError
+		}
+	}
+	return exitProblems
+}
+
+// progressReporter reports the phase a load is in and the packages scanned
+// so far.
+type progressReporter struct {
+	scanned int
+}
+
+func (r *progressReporter) event(e proteus.Event) {
+	switch e.Kind {
+	case proteus.PhaseStarted:
+		if e.Phase == proteus.ResolvePhase {
+			report.Info("resolving %d package(s)", r.scanned)
+		} else {
+			report.Info("scanning packages")
+		}
+	case proteus.PackageScanned:
+		p := e.Progress
+		r.scanned = p.Scanned
+		log := report.With(report.Fields{"package": p.Path})
+		if p.Err != nil {
+			log.Info("scanned %d/%d packages, this one failed", p.Scanned, p.Total)
+		} else {
+			log.Info("scanned %d/%d packages", p.Scanned, p.Total)
+		}
+	}
+}
+
+// summary is the machine readable result of a command.
+type summary struct {
+	Packages int   `json:"packages"`
+	Messages int   `json:"messages"`
+	Enums    int   `json:"enums"`
+	Warnings int32 `json:"warnings"`
+	Errors   int32 `json:"errors"`
+	ExitCode int   `json:"exit_code"`
+}
+
+// countPackages adds the packages and the messages and enums in them to
+// the summary.
+func (s *summary) countPackages(pkgs resolver.Packages) {
+	s.Packages += len(pkgs)
+	for _, p := range pkgs {
+		s.Messages += len(p.Structs)
+		s.Enums += len(p.Enums)
+	}
+}
+
+// countReports makes the summary count all the warnings and errors
+// reported from now on.
+func (s *summary) countReports() {
+	report.AddHook(func(e *report.Entry) {
+		switch e.Level {
+		case report.WarnLevel:
+			atomic.AddInt32(&s.Warnings, 1)
+		case report.ErrorLevel:
+			atomic.AddInt32(&s.Errors, 1)
+		}
+	})
+}
+
+// finish sets the exit code of the summary and writes it to the file given
+// in the options, if any. It is not written to the standard output, which
+// is where the result of the command is. The exit code is returned, unless
+// the summary could not be written.
+func (s *summary) finish(o *options, code int) int {
+	s.ExitCode = code
+	if o.summary == "" {
+		return code
+	}
+
+	if err := s.write(o.summary); err != nil {
+		report.Error("unable to write summary: %s", err)
+		return exitIOError
+	}
+	return code
+}
+
+func (s *summary) write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
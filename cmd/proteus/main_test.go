@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/src-d/proteus"
+	"github.com/src-d/proteus/report"
+	"github.com/src-d/proteus/scanner"
+	"github.com/stretchr/testify/require"
+)
+
+var gopath = os.Getenv("GOPATH")
+
+const project = "github.com/src-d/proteus"
+
+func TestSummaryFinish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	s := &summary{Packages: 2, Messages: 3, Warnings: 1}
+
+	require.Equal(t, exitProblems, s.finish(&options{summary: path}, exitProblems))
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+
+	var written summary
+	require.Nil(t, json.Unmarshal(data, &written))
+	require.Equal(t, summary{Packages: 2, Messages: 3, Warnings: 1, ExitCode: exitProblems}, written)
+}
+
+func TestSummaryFinishWithoutFile(t *testing.T) {
+	s := new(summary)
+	require.Equal(t, exitOK, s.finish(new(options), exitOK))
+	require.Equal(t, exitOK, s.ExitCode)
+}
+
+func TestSummaryFinishWriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonexistent", "summary.json")
+	require.Equal(t, exitIOError, new(summary).finish(&options{summary: path}, exitOK))
+}
+
+func TestLoadExitCode(t *testing.T) {
+	unsupported := fmt.Errorf("error scanning package %q: %w", "foo", fmt.Errorf("%w: field Foo.C", scanner.ErrUnsupported))
+	scanErr := fmt.Errorf("error scanning package %q: %s", "bar", "undefined: Baz")
+
+	cases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"no packages", proteus.ErrNoPackages, exitUsage},
+		{"not scanned", errors.New("undefined: Foo"), exitScanError},
+		{"unsupported fields", errors.Join(unsupported), exitProblems},
+		{"several unsupported fields", errors.Join(unsupported, unsupported), exitProblems},
+		{"unsupported fields and scan errors", errors.Join(unsupported, scanErr), exitScanError},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.expected, loadExitCode(c.err), c.name)
+	}
+}
+
+func TestLoadExitCodeRejectedFields(t *testing.T) {
+	opts := &options{
+		paths:       pathList{writePackage(t, "package foo\n\ntype Foo struct {\n\tC chan int\n}\n")},
+		unsupported: "error",
+	}
+	_, err := opts.load()
+	require.NotNil(t, err)
+	require.Equal(t, exitProblems, loadExitCode(err))
+}
+
+func TestLoadPatternsWithGlobCharacters(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "a[1]")
+	require.Nil(t, os.Mkdir(dir, 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "foo.go"), []byte("package foo\n\ntype Foo struct {\n\tA int\n}\n"), 0644))
+
+	opts := &options{paths: pathList{root + "/..."}, unsupported: "skip"}
+	pkgs, err := opts.load()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(pkgs))
+	require.Equal(t, dir, pkgs[0].Path)
+}
+
+func TestLoadProgress(t *testing.T) {
+	var buf bytes.Buffer
+	report.SetOutput(&buf)
+	defer report.SetOutput(os.Stderr)
+
+	opts := &options{
+		paths:       pathList{projectPath("fixtures"), projectPath("fixtures/subpkg")},
+		unsupported: "skip",
+		progress:    true,
+	}
+	_, err := opts.load()
+	require.Nil(t, err)
+
+	out := buf.String()
+	require.Contains(t, out, "INFO: scanning packages")
+	require.Contains(t, out, "INFO: scanned 1/2 packages")
+	require.Contains(t, out, "INFO: scanned 2/2 packages")
+	require.Contains(t, out, "INFO: resolving 2 package(s)")
+	require.True(t, strings.Index(out, "scanned 2/2") < strings.Index(out, "resolving"), "phases should be reported in order")
+}
+
+// writePackage writes a package with a single file with the given source
+// to a temporary directory and returns its path.
+func writePackage(t *testing.T, src string) string {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "foo.go"), []byte(src), 0644))
+	return dir
+}
+
+func projectPath(pkg string) string {
+	return filepath.Join(gopath, "src", project, pkg)
+}
@@ -0,0 +1,119 @@
+package proteus
+
+import (
+	"sync"
+
+	"github.com/src-d/proteus/report"
+	"github.com/src-d/proteus/scanner"
+)
+
+// Phase is one of the steps Load goes through.
+type Phase int
+
+const (
+	// ScanPhase is the phase in which packages are parsed and type checked.
+	ScanPhase Phase = iota
+	// ResolvePhase is the phase in which the types of all the scanned
+	// packages are resolved.
+	ResolvePhase
+)
+
+func (p Phase) String() string {
+	switch p {
+	case ScanPhase:
+		return "scan"
+	case ResolvePhase:
+		return "resolve"
+	default:
+		return "unknown"
+	}
+}
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// PhaseStarted is sent when a phase starts.
+	PhaseStarted EventKind = iota
+	// PhaseFinished is sent when a phase finishes successfully.
+	PhaseFinished
+	// PackageScanned is sent every time a package has been scanned.
+	PackageScanned
+	// Reported is sent for every warning or error reported.
+	Reported
+)
+
+// Event is something that happened during a Load. Only the fields that
+// apply to its kind are set.
+type Event struct {
+	Kind EventKind
+	// Phase is the phase that started or finished.
+	Phase Phase
+	// Progress is the progress of the scan after a package was scanned.
+	Progress scanner.Progress
+	// Entry is the warning or error reported.
+	Entry *report.Entry
+}
+
+// Listener receives the events of a Load. It is never called concurrently,
+// and it must not report warnings or errors with the report package itself,
+// as they would be sent back to it.
+// Reported events come from the report package, which is shared by the
+// whole program, so they include the warnings and errors of other Loads
+// running at the same time.
+type Listener func(Event)
+
+// listener sends events to a Listener, if any, one at a time.
+type listener struct {
+	mut    sync.Mutex
+	fn     Listener
+	remove func()
+}
+
+func newListener(fn Listener) *listener {
+	l := &listener{fn: fn}
+	if fn != nil {
+		l.remove = report.AddHook(func(e *report.Entry) {
+			if e.Level >= report.WarnLevel {
+				l.send(Event{Kind: Reported, Entry: e})
+			}
+		})
+	}
+	return l
+}
+
+func (l *listener) send(e Event) {
+	if l.fn == nil {
+		return
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.fn(e)
+}
+
+func (l *listener) phase(kind EventKind, p Phase) {
+	l.send(Event{Kind: kind, Phase: p})
+}
+
+// progress returns a ProgressFunc that sends PackageScanned events after
+// calling the given one, if any.
+func (l *listener) progress(next scanner.ProgressFunc) scanner.ProgressFunc {
+	if l.fn == nil {
+		return next
+	}
+
+	return func(p scanner.Progress) {
+		if next != nil {
+			next(p)
+		}
+		l.send(Event{Kind: PackageScanned, Progress: p})
+	}
+}
+
+// close stops sending Reported events.
+func (l *listener) close() {
+	if l.remove != nil {
+		l.remove()
+	}
+}
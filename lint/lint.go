@@ -0,0 +1,199 @@
+package lint
+
+import (
+	"fmt"
+	"go/constant"
+	"go/token"
+	"math"
+	"sort"
+
+	"github.com/src-d/proteus/resolver"
+	"github.com/src-d/proteus/scanner"
+)
+
+// Problem is something found in a Go package that prevents it from being
+// converted to protobuf as it is.
+type Problem struct {
+	Package string
+	Struct  string
+	Field   string
+	Message string
+	// Pos is the position in the source of the offending declaration, if
+	// it is known.
+	Pos token.Position
+}
+
+func (p *Problem) String() string {
+	var prefix string
+	if p.Pos.IsValid() {
+		prefix = p.Pos.String() + ": "
+	}
+
+	switch {
+	case p.Field != "":
+		return fmt.Sprintf("%s%s.%s.%s: %s", prefix, p.Package, p.Struct, p.Field, p.Message)
+	case p.Struct != "":
+		return fmt.Sprintf("%s%s.%s: %s", prefix, p.Package, p.Struct, p.Message)
+	default:
+		return fmt.Sprintf("%s%s: %s", prefix, p.Package, p.Message)
+	}
+}
+
+// Check looks for proto compatibility problems in the given packages, which
+// must have been already resolved.
+// Problems the scanner and resolver can deal with on their own by ignoring
+// the offending types are not reported here, as they already report them
+// as warnings or errors.
+func Check(pkgs resolver.Packages) []*Problem {
+	var problems []*Problem
+	problems = append(problems, checkNameCollisions(pkgs)...)
+	problems = append(problems, checkEnumValues(pkgs)...)
+	problems = append(problems, checkZeroEnumValue(pkgs)...)
+	return problems
+}
+
+// checkNameCollisions reports the structs and enums whose name is used by
+// another struct or enum, in the same package or in a different one, as both
+// would end up being the same message once all packages are imported
+// together.
+func checkNameCollisions(pkgs resolver.Packages) []*Problem {
+	type occurrence struct {
+		pkg string
+		pos token.Position
+	}
+
+	var (
+		names = make(map[string][]occurrence)
+		order []string
+	)
+
+	add := func(name, pkg string, pos token.Position) {
+		if _, ok := names[name]; !ok {
+			order = append(order, name)
+		}
+		names[name] = append(names[name], occurrence{pkg, pos})
+	}
+
+	for _, p := range pkgs {
+		for _, s := range p.Structs {
+			add(s.Name, p.Path, s.Pos)
+		}
+		for _, e := range p.Enums {
+			add(e.Name, p.Path, token.Position{})
+		}
+	}
+
+	var problems []*Problem
+	for _, name := range order {
+		occurrences := names[name]
+		if len(occurrences) < 2 {
+			continue
+		}
+
+		count := make(map[string]int)
+		var paths []string
+		for _, o := range occurrences {
+			if count[o.pkg] == 0 {
+				paths = append(paths, o.pkg)
+			}
+			count[o.pkg]++
+		}
+		sort.Strings(paths)
+
+		sort.SliceStable(occurrences, func(i, j int) bool {
+			return occurrences[i].pkg < occurrences[j].pkg
+		})
+
+		for _, o := range occurrences {
+			var msg string
+			switch {
+			case count[o.pkg] == 1:
+				msg = fmt.Sprintf("name collides with types in other packages: %v", paths)
+			case len(paths) == 1:
+				msg = fmt.Sprintf("name is used by %d types in the package", count[o.pkg])
+			default:
+				msg = fmt.Sprintf("name is used by %d types in the package and collides with types in other packages: %v", count[o.pkg], paths)
+			}
+
+			problems = append(problems, &Problem{
+				Package: o.pkg,
+				Struct:  name,
+				Message: msg,
+				Pos:     o.pos,
+			})
+		}
+	}
+	return problems
+}
+
+var (
+	minEnumValue = constant.MakeInt64(math.MinInt32)
+	maxEnumValue = constant.MakeInt64(math.MaxInt32)
+)
+
+// checkEnumValues reports the values of integer enums that do not fit in
+// an int32, which is what proto enum values are. Enums of smaller types,
+// such as int8 or uint16, always fit, but the values of proto enums still
+// need to be checked to fit in them when converted back to Go.
+func checkEnumValues(pkgs resolver.Packages) []*Problem {
+	var problems []*Problem
+	for _, p := range pkgs {
+		for _, e := range p.Enums {
+			for _, v := range e.Values {
+				if v.Value.Kind() != constant.Int {
+					continue
+				}
+
+				if constant.Compare(v.Value, token.LSS, minEnumValue) ||
+					constant.Compare(v.Value, token.GTR, maxEnumValue) {
+					problems = append(problems, &Problem{
+						Package: p.Path,
+						Struct:  e.Name,
+						Field:   v.Name,
+						Message: fmt.Sprintf("value %s does not fit in the int32 range of proto enums", v.Value),
+					})
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// checkZeroEnumValue reports the integer enums without a value equal to 0,
+// as proto3 enums need one to be their default value. String enums are not
+// checked, as their values are converted by name.
+func checkZeroEnumValue(pkgs resolver.Packages) []*Problem {
+	var problems []*Problem
+	for _, p := range pkgs {
+		for _, e := range p.Enums {
+			if !hasIntValues(e) || hasZeroValue(e) {
+				continue
+			}
+
+			problems = append(problems, &Problem{
+				Package: p.Path,
+				Struct:  e.Name,
+				Message: "enum has no value equal to 0, which proto3 enums need as their default value",
+			})
+		}
+	}
+	return problems
+}
+
+func hasIntValues(e *scanner.Enum) bool {
+	for _, v := range e.Values {
+		if v.Value.Kind() == constant.Int {
+			return true
+		}
+	}
+	return false
+}
+
+func hasZeroValue(e *scanner.Enum) bool {
+	for _, v := range e.Values {
+		if v.Value.Kind() == constant.Int && constant.Sign(v.Value) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package lint
+
+import (
+	"go/constant"
+	"go/token"
+	"math"
+	"testing"
+
+	"github.com/src-d/proteus/resolver"
+	"github.com/src-d/proteus/scanner"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckNameCollisions(t *testing.T) {
+	pkgs := resolver.Packages{
+		&scanner.Package{
+			Path: "foo",
+			Structs: []*scanner.Struct{
+				{Name: "Status", Pos: token.Position{Filename: "foo/status.go", Line: 3, Column: 6}},
+				{Name: "Foo"},
+			},
+		},
+		&scanner.Package{
+			Path:  "bar",
+			Enums: []*scanner.Enum{{Name: "Status"}},
+		},
+	}
+
+	require.Equal(t, []*Problem{
+		{"bar", "Status", "", "name collides with types in other packages: [bar foo]", token.Position{}},
+		{"foo", "Status", "", "name collides with types in other packages: [bar foo]", token.Position{Filename: "foo/status.go", Line: 3, Column: 6}},
+	}, Check(pkgs))
+}
+
+func TestCheckNameCollisionsInPackage(t *testing.T) {
+	first := token.Position{Filename: "foo/a.go", Line: 3, Column: 6}
+	second := token.Position{Filename: "foo/b.go", Line: 5, Column: 6}
+	pkgs := resolver.Packages{
+		&scanner.Package{
+			Path: "foo",
+			Structs: []*scanner.Struct{
+				{Name: "Entry", Pos: first},
+				{Name: "Entry", Pos: second},
+				{Name: "Foo"},
+			},
+		},
+		&scanner.Package{
+			Path: "bar",
+			Structs: []*scanner.Struct{
+				{Name: "Foo"},
+				{Name: "Foo"},
+			},
+		},
+	}
+
+	require.Equal(t, []*Problem{
+		{"foo", "Entry", "", "name is used by 2 types in the package", first},
+		{"foo", "Entry", "", "name is used by 2 types in the package", second},
+		{"bar", "Foo", "", "name is used by 2 types in the package and collides with types in other packages: [bar foo]", token.Position{}},
+		{"bar", "Foo", "", "name is used by 2 types in the package and collides with types in other packages: [bar foo]", token.Position{}},
+		{"foo", "Foo", "", "name collides with types in other packages: [bar foo]", token.Position{}},
+	}, Check(pkgs))
+}
+
+func TestProblemString(t *testing.T) {
+	p := &Problem{Package: "foo", Struct: "Bar", Field: "Baz", Message: "wrong"}
+	require.Equal(t, "foo.Bar.Baz: wrong", p.String())
+
+	p.Pos = token.Position{Filename: "foo/bar.go", Line: 4, Column: 2}
+	require.Equal(t, "foo/bar.go:4:2: foo.Bar.Baz: wrong", p.String())
+}
+
+func TestCheckEnumValues(t *testing.T) {
+	pkgs := resolver.Packages{
+		&scanner.Package{
+			Path: "foo",
+			Enums: []*scanner.Enum{
+				{
+					Name:       "Small",
+					Underlying: "int8",
+					Values: []*scanner.EnumValue{
+						{Name: "SmallA", Value: constant.MakeInt64(-128)},
+						{Name: "SmallZero", Value: constant.MakeInt64(0)},
+						{Name: "SmallB", Value: constant.MakeInt64(127)},
+					},
+				},
+				{
+					Name:       "Big",
+					Underlying: "uint64",
+					Values: []*scanner.EnumValue{
+						{Name: "BigZero", Value: constant.MakeUint64(0)},
+						{Name: "BigA", Value: constant.MakeInt64(math.MaxInt32)},
+						{Name: "BigB", Value: constant.MakeUint64(math.MaxUint64)},
+					},
+				},
+				{
+					Name:       "Kind",
+					Underlying: "string",
+					Values: []*scanner.EnumValue{
+						{Name: "KindA", Value: constant.MakeString("a")},
+					},
+				},
+			},
+		},
+	}
+
+	require.Equal(t, []*Problem{
+		{"foo", "Big", "BigB", "value 18446744073709551615 does not fit in the int32 range of proto enums", token.Position{}},
+	}, Check(pkgs))
+}
+
+func TestCheckZeroEnumValue(t *testing.T) {
+	pkgs := resolver.Packages{
+		&scanner.Package{
+			Path: "foo",
+			Enums: []*scanner.Enum{
+				{
+					Name:       "Size",
+					Underlying: "int",
+					Values: []*scanner.EnumValue{
+						{Name: "Small", Value: constant.MakeInt64(1)},
+						{Name: "Large", Value: constant.MakeInt64(2)},
+					},
+				},
+				{
+					Name:       "Level",
+					Underlying: "int8",
+					Values: []*scanner.EnumValue{
+						{Name: "Low", Value: constant.MakeInt64(-1)},
+						{Name: "None", Value: constant.MakeInt64(0)},
+					},
+				},
+				{
+					Name:       "Kind",
+					Underlying: "string",
+					Values: []*scanner.EnumValue{
+						{Name: "KindA", Value: constant.MakeString("a")},
+					},
+				},
+			},
+		},
+	}
+
+	require.Equal(t, []*Problem{
+		{"foo", "Size", "", "enum has no value equal to 0, which proto3 enums need as their default value", token.Position{}},
+	}, Check(pkgs))
+}
@@ -1 +1,104 @@
+// Package proteus is the entry point to use proteus as a library. It runs
+// the same steps as the proteus command, scanning Go packages and resolving
+// their types, and returns the resulting model instead of printing it.
 package proteus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/src-d/proteus/resolver"
+	"github.com/src-d/proteus/scanner"
+)
+
+// ErrNoPackages is returned by Load when no package matches the paths.
+var ErrNoPackages = errors.New("no packages matched the given paths")
+
+// Options are the options to load packages with.
+type Options struct {
+	// Paths are the directories of the packages to load. They accept the
+	// patterns documented in scanner.ExpandPaths.
+	Paths []string
+	// Exclude are the directories of the packages to leave out, with the
+	// same patterns as Paths.
+	Exclude []string
+	// MapEntries converts maps with key types not valid in protobuf to
+	// repeated key-value messages instead of ignoring them.
+	MapEntries bool
+	// CustomTypes are the full names, such as github.com/foo/bar.Money, of
+	// the named types to keep as they are even if their package is not
+	// scanned. See resolver.Resolver.AddCustomType.
+	CustomTypes []string
+	// ProtoTypes maps Go types to the proto scalar type their fields get.
+	// See resolver.Resolver.SetProtoType.
+	ProtoTypes map[string]string
+	// Scanner are the options the packages are scanned with.
+	Scanner scanner.Options
+	// Listener, if not nil, receives the events of the Load as it runs.
+	Listener Listener
+}
+
+// Load scans and resolves the packages matching the options. It stops as
+// soon as the context is done and returns its error.
+func Load(ctx context.Context, opts Options) (resolver.Packages, error) {
+	paths, err := scanner.ExpandPaths(opts.Paths, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, ErrNoPackages
+	}
+
+	sc, err := scanner.New(paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newResolver(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newListener(opts.Listener)
+	defer l.close()
+
+	sc.Options = opts.Scanner
+	sc.Progress = l.progress(opts.Scanner.Progress)
+
+	l.phase(PhaseStarted, ScanPhase)
+	pkgs, err := sc.ScanContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.phase(PhaseFinished, ScanPhase)
+
+	l.phase(PhaseStarted, ResolvePhase)
+	if err := r.ResolveContext(ctx, pkgs); err != nil {
+		return nil, err
+	}
+	l.phase(PhaseFinished, ResolvePhase)
+	return pkgs, nil
+}
+
+func newResolver(opts Options) (*resolver.Resolver, error) {
+	r := resolver.New()
+	r.MapEntries = opts.MapEntries
+
+	for _, t := range opts.CustomTypes {
+		idx := strings.LastIndex(t, ".")
+		if idx <= 0 || idx == len(t)-1 {
+			return nil, fmt.Errorf("invalid custom type %q, it must be a package path followed by a dot and a type name", t)
+		}
+		r.AddCustomType(t[:idx], t[idx+1:])
+	}
+
+	for goType, protoType := range opts.ProtoTypes {
+		if err := r.SetProtoType(goType, protoType); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
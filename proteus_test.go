@@ -0,0 +1,96 @@
+package proteus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/src-d/proteus/report"
+	"github.com/stretchr/testify/require"
+)
+
+var gopath = os.Getenv("GOPATH")
+
+const project = "github.com/src-d/proteus"
+
+func TestLoad(t *testing.T) {
+	pkgs, err := Load(context.Background(), Options{
+		Paths:   []string{projectPath("fixtures/...")},
+		Exclude: []string{projectPath("fixtures/subpkg")},
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, 1, len(pkgs))
+	require.Equal(t, projectPath("fixtures"), pkgs[0].Path)
+	require.True(t, pkgs[0].Resolved)
+	require.NotEqual(t, 0, len(pkgs[0].Structs))
+}
+
+func TestLoadNoPackages(t *testing.T) {
+	_, err := Load(context.Background(), Options{
+		Paths:   []string{projectPath("fixtures/subpkg")},
+		Exclude: []string{projectPath("fixtures/subpkg")},
+	})
+	require.Equal(t, ErrNoPackages, err)
+}
+
+func TestLoadInvalidTypes(t *testing.T) {
+	_, err := Load(context.Background(), Options{
+		Paths:       []string{projectPath("fixtures")},
+		CustomTypes: []string{"Money"},
+	})
+	require.NotNil(t, err)
+
+	_, err = Load(context.Background(), Options{
+		Paths:      []string{projectPath("fixtures")},
+		ProtoTypes: map[string]string{"int": "varint"},
+	})
+	require.NotNil(t, err)
+}
+
+func TestLoadCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Load(ctx, Options{Paths: []string{projectPath("fixtures")}})
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestLoadEvents(t *testing.T) {
+	var (
+		phases   []string
+		scanned  []string
+		reported int
+	)
+
+	_, err := Load(context.Background(), Options{
+		Paths: []string{projectPath("fixtures"), projectPath("fixtures/subpkg")},
+		Listener: func(e Event) {
+			switch e.Kind {
+			case PhaseStarted:
+				phases = append(phases, "start "+e.Phase.String())
+			case PhaseFinished:
+				phases = append(phases, "finish "+e.Phase.String())
+			case PackageScanned:
+				scanned = append(scanned, e.Progress.Path)
+			case Reported:
+				require.True(t, e.Entry.Level >= report.WarnLevel)
+				reported++
+			}
+		},
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, []string{"start scan", "finish scan", "start resolve", "finish resolve"}, phases)
+	require.Equal(t, 2, len(scanned))
+	require.NotEqual(t, 0, reported, "the fixtures have fields with types out of the scan path")
+
+	n := reported
+	report.Warn("after load")
+	require.Equal(t, n, reported, "listener should not receive reports after Load")
+}
+
+func projectPath(pkg string) string {
+	return filepath.Join(gopath, "src", project, pkg)
+}
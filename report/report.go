@@ -1,25 +1,250 @@
 package report
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/fatih/color"
 )
 
 type Color func(string, ...interface{}) string
 
+// Level is the severity of a reported message. Messages with a level lower
+// than the one set with SetLevel are discarded.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+func (l Level) color() Color {
+	switch l {
+	case DebugLevel:
+		return color.CyanString
+	case InfoLevel:
+		return color.GreenString
+	case WarnLevel:
+		return color.YellowString
+	default:
+		return color.RedString
+	}
+}
+
+// Format is the way messages are written to the output.
+type Format int
+
+const (
+	// TextFormat writes human readable lines with the level colored.
+	TextFormat Format = iota
+	// JSONFormat writes a JSON object per line with the level, the message
+	// and all the fields attached to it.
+	JSONFormat
+)
+
+// Fields are key-value pairs attached to a message to give context about
+// what produced it, e.g. the package or the type being processed.
+type Fields map[string]interface{}
+
+// Entry is a single reported message.
+type Entry struct {
+	Level   Level
+	Message string
+	Fields  Fields
+}
+
+// Hook is a function that receives every reported entry, regardless of
+// the level set with SetLevel.
+type Hook func(*Entry)
+
+var (
+	mut    sync.Mutex
+	level  = InfoLevel
+	format = TextFormat
+	output = io.Writer(os.Stderr)
+	hooks  []*Hook
+)
+
+// AddHook registers a hook that will be called with every reported entry.
+// Hooks are called after the entry is written, so they are free to report
+// messages on their own. The returned function removes the hook.
+func AddHook(h Hook) (remove func()) {
+	mut.Lock()
+	defer mut.Unlock()
+
+	hp := &h
+	hooks = append(hooks, hp)
+	return func() {
+		mut.Lock()
+		defer mut.Unlock()
+
+		// entries being reported keep using the previous slice, so it is
+		// not modified in place
+		var hs []*Hook
+		for _, other := range hooks {
+			if other != hp {
+				hs = append(hs, other)
+			}
+		}
+		hooks = hs
+	}
+}
+
+// SetLevel sets the minimum level a message must have to be reported.
+// Setting it to ErrorLevel makes the report quiet except for errors.
+func SetLevel(l Level) {
+	mut.Lock()
+	defer mut.Unlock()
+	level = l
+}
+
+// SetFormat sets the format in which messages are written.
+func SetFormat(f Format) {
+	mut.Lock()
+	defer mut.Unlock()
+	format = f
+}
+
+// SetOutput sets the writer messages are written to. By default, messages
+// are written to the standard error.
+func SetOutput(w io.Writer) {
+	mut.Lock()
+	defer mut.Unlock()
+	output = w
+}
+
+// Logger reports messages with a set of fields attached to all of them.
+type Logger struct {
+	fields Fields
+}
+
+// With returns a Logger that attaches the given fields to every message.
+func With(fields Fields) *Logger {
+	return (&Logger{}).With(fields)
+}
+
+// With returns a new Logger with the fields of the current one plus the
+// given fields. Given fields take precedence over the existing ones.
+func (l *Logger) With(fields Fields) *Logger {
+	fs := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		fs[k] = v
+	}
+	for k, v := range fields {
+		fs[k] = v
+	}
+	return &Logger{fs}
+}
+
+func (l *Logger) Debug(format string, args ...interface{}) {
+	l.report(DebugLevel, format, args...)
+}
+
+func (l *Logger) Info(format string, args ...interface{}) {
+	l.report(InfoLevel, format, args...)
+}
+
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.report(WarnLevel, format, args...)
+}
+
+func (l *Logger) Error(format string, args ...interface{}) {
+	l.report(ErrorLevel, format, args...)
+}
+
+func (l *Logger) report(lvl Level, msgFormat string, args ...interface{}) {
+	msg := fmt.Sprintf(msgFormat, args...)
+
+	mut.Lock()
+	if lvl >= level {
+		switch format {
+		case JSONFormat:
+			writeJSON(output, lvl, msg, l.fields)
+		default:
+			writeText(output, lvl, msg, l.fields)
+		}
+	}
+	hs := hooks
+	mut.Unlock()
+
+	for _, h := range hs {
+		(*h)(&Entry{lvl, msg, l.fields})
+	}
+}
+
+func writeText(w io.Writer, lvl Level, msg string, fields Fields) {
+	line := fmt.Sprintf("%s: %s", lvl.color()(lvl.String()), msg)
+	if len(fields) > 0 {
+		var pairs []string
+		for _, k := range sortedKeys(fields) {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, fields[k]))
+		}
+		line += " " + strings.Join(pairs, " ")
+	}
+	fmt.Fprintln(w, line)
+}
+
+func writeJSON(w io.Writer, lvl Level, msg string, fields Fields) {
+	entry := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["level"] = strings.ToLower(lvl.String())
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		writeText(w, lvl, msg, fields)
+		return
+	}
+	fmt.Fprintln(w, string(data))
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var std = new(Logger)
+
+func Debug(format string, args ...interface{}) {
+	std.Debug(format, args...)
+}
+
 func Warn(format string, args ...interface{}) {
-	report(color.YellowString, "WARN", format, args...)
+	std.Warn(format, args...)
 }
 
 func Error(format string, args ...interface{}) {
-	report(color.RedString, "ERROR", format, args...)
+	std.Error(format, args...)
 }
 
 func Info(format string, args ...interface{}) {
-	report(color.GreenString, "INFO", format, args...)
-}
-
-func report(color Color, lvl string, format string, args ...interface{}) {
-	fmt.Printf("%s: %s\n", color(lvl), fmt.Sprintf(format, args...))
+	std.Info(format, args...)
 }
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportLevel(t *testing.T) {
+	var buf bytes.Buffer
+	defer setup(&buf, WarnLevel, TextFormat)()
+
+	Info("not reported")
+	Warn("reported %d", 1)
+	Error("reported %d", 2)
+
+	require.Equal(t, "WARN: reported 1\nERROR: reported 2\n", buf.String())
+}
+
+func TestReportTextFields(t *testing.T) {
+	var buf bytes.Buffer
+	defer setup(&buf, DebugLevel, TextFormat)()
+
+	log := With(Fields{"package": "foo", "struct": "Bar"})
+	log.With(Fields{"field": "Baz"}).Debug("a message")
+	log.Info("another message")
+
+	require.Equal(
+		t,
+		"DEBUG: a message field=Baz package=foo struct=Bar\nINFO: another message package=foo struct=Bar\n",
+		buf.String(),
+	)
+}
+
+func TestReportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	defer setup(&buf, InfoLevel, JSONFormat)()
+
+	With(Fields{"package": "foo"}).Warn("ignoring type %s", "chan int")
+
+	require.Equal(
+		t,
+		`{"level":"warn","msg":"ignoring type chan int","package":"foo"}`+"\n",
+		buf.String(),
+	)
+}
+
+func TestReportHook(t *testing.T) {
+	var buf bytes.Buffer
+	defer setup(&buf, ErrorLevel, TextFormat)()
+
+	var entries []*Entry
+	remove := AddHook(func(e *Entry) {
+		entries = append(entries, e)
+	})
+	defer func() { hooks = nil }()
+
+	With(Fields{"package": "foo"}).Warn("a warning")
+	require.Equal(t, "", buf.String(), "warning should not be written")
+	require.Equal(t, []*Entry{
+		{WarnLevel, "a warning", Fields{"package": "foo"}},
+	}, entries)
+
+	remove()
+	Error("an error")
+	require.Equal(t, 1, len(entries), "removed hook should not be called")
+}
+
+func setup(buf *bytes.Buffer, lvl Level, f Format) func() {
+	noColor := color.NoColor
+	color.NoColor = true
+	SetOutput(buf)
+	SetLevel(lvl)
+	SetFormat(f)
+
+	return func() {
+		color.NoColor = noColor
+		SetOutput(os.Stderr)
+		SetLevel(InfoLevel)
+		SetFormat(TextFormat)
+	}
+}
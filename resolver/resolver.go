@@ -1,7 +1,9 @@
 package resolver
 
 import (
+	"context"
 	"fmt"
+	"go/token"
 
 	"github.com/src-d/proteus/report"
 	"github.com/src-d/proteus/scanner"
@@ -15,7 +17,12 @@ import (
 // field would be changed from a named `IntList` type to a repeated basic
 // type `int`.
 type Resolver struct {
+	// MapEntries makes fields with maps whose key type is not valid in
+	// protobuf be converted to a repeated message with the key and the
+	// value as fields instead of being ignored.
+	MapEntries  bool
 	customTypes map[string]struct{}
+	protoTypes  map[string]string
 }
 
 func New() *Resolver {
@@ -23,19 +30,88 @@ func New() *Resolver {
 		customTypes: map[string]struct{}{
 			"time.Time":     struct{}{},
 			"time.Duration": struct{}{},
+			// civil dates and times have no time zone, so they do not fit
+			// in a timestamp and will map to the google.type ones
+			"cloud.google.com/go/civil.Date":     struct{}{},
+			"cloud.google.com/go/civil.Time":     struct{}{},
+			"cloud.google.com/go/civil.DateTime": struct{}{},
 		},
+		protoTypes: make(map[string]string),
 	}
 }
 
+// AddCustomType makes fields of the named type with the given package path
+// and name be kept as they are, even if its package is not scanned, the
+// same way fields of type time.Time are.
+func (r *Resolver) AddCustomType(path, name string) {
+	r.customTypes[path+"."+name] = struct{}{}
+}
+
+// SetProtoType makes fields of the given Go type have the given proto
+// scalar type, as if it was forced with the `type=` option of their tag.
+// The Go type is either the name of a basic type, such as int, or the full
+// name of a named type, such as time.Duration. The named types of scanned
+// packages are named after the directory of their package, the same way
+// their Path is, e.g. /go/src/github.com/foo/bar.ID. Types forced in the
+// tag of a field take precedence, and repeated fields are not affected.
+// Custom types can be given any scalar type and enums any integral type
+// or string, while other types can only be given the proto types
+// compatible with the type they resolve to.
+// An error is returned if the proto type is not a scalar type.
+func (r *Resolver) SetProtoType(goType, protoType string) error {
+	if _, ok := scalarProtoTypes[protoType]; !ok {
+		return fmt.Errorf("%q is not a proto scalar type", protoType)
+	}
+
+	r.protoTypes[goType] = protoType
+	return nil
+}
+
+// setProtoType sets the proto type registered for the type of the field,
+// if any and no other one was forced for it. It returns the name of the
+// type the proto type was registered for, which is empty if none was set.
+func (r *Resolver) setProtoType(f *scanner.Field) string {
+	if f.ProtoType != "" || f.Type.IsRepeated() {
+		return ""
+	}
+
+	var name string
+	switch t := f.Type.(type) {
+	case *scanner.Basic:
+		name = t.Name
+	case *scanner.Named:
+		name = t.String()
+	default:
+		return ""
+	}
+
+	f.ProtoType = r.protoTypes[name]
+	if f.ProtoType == "" {
+		return ""
+	}
+	return name
+}
+
 // Resolve checks the types of all the packages passed in a global manner.
 // Also, it sets to `true` the `Resolved` field of the package, meaning that
 // they can be safely used after it.
 func (r *Resolver) Resolve(pkgs Packages) {
+	_ = r.ResolveContext(context.Background(), pkgs)
+}
+
+// ResolveContext is like Resolve, but it stops before resolving the next
+// package once the context is done and returns its error. Packages not
+// resolved by then keep their Resolved field set to false.
+func (r *Resolver) ResolveContext(ctx context.Context, pkgs Packages) error {
 	info := pkgs.Info()
 
 	for _, p := range pkgs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		r.resolvePackage(p, info)
 	}
+	return nil
 }
 
 func (r *Resolver) isCustomType(n *scanner.Named) bool {
@@ -43,19 +119,149 @@ func (r *Resolver) isCustomType(n *scanner.Named) bool {
 	return ok
 }
 
+// isKnownType reports whether the type is a custom type or a named type of
+// one of the scanned packages.
+func (r *Resolver) isKnownType(t scanner.Type, info *PackagesInfo) bool {
+	n, ok := t.(*scanner.Named)
+	if !ok {
+		return true
+	}
+
+	if r.isCustomType(n) {
+		return true
+	}
+
+	_, ok = info.Packages[n.Path]
+	return ok
+}
+
 func (r *Resolver) resolvePackage(p *scanner.Package, info *PackagesInfo) {
+	log := report.With(report.Fields{"package": p.Path})
+	var (
+		entries []*scanner.Struct
+		names   = make(map[string]struct{})
+	)
 	for _, s := range p.Structs {
-		s.Fields = r.resolveStructFields(s.Fields, info)
+		structLog := log.With(posFields(report.Fields{"struct": s.Name}, s.Pos))
+		s.Fields = r.resolveStructFields(structLog, s.Fields, info)
+		entries = append(entries, r.resolveMapKeys(structLog, p, s, names)...)
 	}
+	p.Structs = append(p.Structs, entries...)
 	p.Resolved = true
 }
 
-func (r *Resolver) resolveStructFields(fields []*scanner.Field, info *PackagesInfo) []*scanner.Field {
+// resolveMapKeys looks for map fields in the struct whose key type is not
+// valid in protobuf. Those fields are removed, unless the MapEntries option
+// is enabled, in which case they are converted to a repeated message with
+// the key and the value. These new messages are returned so they can be
+// added to the package. The names of the messages already created for the
+// package are in entryNames, and the new ones are added to it.
+func (r *Resolver) resolveMapKeys(log *report.Logger, p *scanner.Package, s *scanner.Struct, entryNames map[string]struct{}) []*scanner.Struct {
+	var (
+		fields  = make([]*scanner.Field, 0, len(s.Fields))
+		entries []*scanner.Struct
+	)
+
+	for _, f := range s.Fields {
+		m, ok := f.Type.(*scanner.Map)
+		if !ok || isValidMapKey(m.Key) {
+			fields = append(fields, f)
+			continue
+		}
+
+		fieldLog := log.With(posFields(report.Fields{"field": f.Name}, f.Pos))
+		if !r.MapEntries {
+			fieldLog.Error("field will be ignored because map key type %s is not valid in protobuf, only integral, boolean and string types can be map keys. %s", typeString(m.Key), invalidMapKeyHint)
+			continue
+		}
+
+		entry := &scanner.Struct{
+			Name: s.Name + f.Name + "Entry",
+			Pos:  f.Pos,
+			Fields: []*scanner.Field{
+				{Name: "Key", Type: m.Key},
+				{Name: "Value", Type: m.Value},
+			},
+		}
+
+		if _, ok := entryNames[entry.Name]; ok || p.HasType(entry.Name) {
+			fieldLog.Error("field will be ignored because its map can not be converted to the message %s, there is already a type with that name", entry.Name)
+			continue
+		}
+
+		entryNames[entry.Name] = struct{}{}
+		f.Type = scanner.NewNamed(p.Path, entry.Name)
+		f.Type.SetRepeated(true)
+		fields = append(fields, f)
+		entries = append(entries, entry)
+	}
+
+	s.Fields = fields
+	return entries
+}
+
+// invalidMapKeyHint is the suggestion given for maps with invalid key types.
+const invalidMapKeyHint = "Use a string or integral key instead, or enable map entries to convert the map to a repeated message with key and value fields"
+
+var invalidKeyTypes = map[string]struct{}{
+	scanner.Any:  struct{}{},
+	"float32":    struct{}{},
+	"float64":    struct{}{},
+	"complex64":  struct{}{},
+	"complex128": struct{}{},
+}
+
+// isValidMapKey reports whether the type can be the key of a protobuf map,
+// that is, any scalar type except floating point types and bytes.
+func isValidMapKey(t scanner.Type) bool {
+	basic, ok := t.(*scanner.Basic)
+	if !ok || basic.IsRepeated() {
+		return false
+	}
+
+	_, invalid := invalidKeyTypes[basic.Name]
+	return !invalid
+}
+
+func typeString(t scanner.Type) string {
+	var name string
+	switch t := t.(type) {
+	case *scanner.Basic:
+		name = t.Name
+	case *scanner.Named:
+		name = t.String()
+	case *scanner.Map:
+		name = fmt.Sprintf("map[%s]%s", typeString(t.Key), typeString(t.Value))
+	}
+
+	if t.IsRepeated() {
+		return "[]" + name
+	}
+	return name
+}
+
+func (r *Resolver) resolveStructFields(log *report.Logger, fields []*scanner.Field, info *PackagesInfo) []*scanner.Field {
 	var result = make([]*scanner.Field, 0, len(fields))
 
 	for _, f := range fields {
-		if typ := r.resolveType(f.Type, info); typ != nil {
+		fieldLog := log.With(posFields(report.Fields{"field": f.Name}, f.Pos))
+
+		// embedded structs without exported fields, such as sync.Mutex, are
+		// usually not part of the data of the struct, so they are silently
+		// ignored unless their type can be resolved
+		if f.Embedded && !r.isKnownType(f.Type, info) {
+			fieldLog.Debug("embedded type %s will be ignored because it was not present on the scan path", typeString(f.Type))
+			continue
+		}
+
+		registered := r.setProtoType(f)
+		if typ := r.resolveType(fieldLog, f.Type, info); typ != nil {
 			f.Type = typ
+			if registered != "" {
+				r.resolveRegisteredProtoType(fieldLog, f, registered, info)
+			} else {
+				resolveProtoType(fieldLog, f)
+			}
 			result = append(result, f)
 		}
 	}
@@ -63,15 +269,120 @@ func (r *Resolver) resolveStructFields(fields []*scanner.Field, info *PackagesIn
 	return result
 }
 
-func (r *Resolver) resolveType(typ scanner.Type, info *PackagesInfo) (result scanner.Type) {
+// posFields adds the given position to the fields if it is valid. The
+// position of a field takes the place of the one of its struct.
+func posFields(fields report.Fields, pos token.Position) report.Fields {
+	if pos.IsValid() {
+		fields["pos"] = pos.String()
+	}
+	return fields
+}
+
+// resolveProtoType checks that the proto type forced for the field, if any,
+// can represent the values of its resolved type. If it can not, the forced
+// type is discarded.
+func resolveProtoType(log *report.Logger, f *scanner.Field) {
+	if f.ProtoType == "" {
+		return
+	}
+
+	if _, ok := compatibleProtoTypes(f.Type)[f.ProtoType]; !ok {
+		log.Warn("forced proto type %q is not compatible with %s and will be ignored", f.ProtoType, typeString(f.Type))
+		f.ProtoType = ""
+	}
+}
+
+// resolveRegisteredProtoType checks that the proto type registered for the
+// Go type of the field, with the given name, can represent the values of
+// its resolved type. If it can not, the registered type is discarded.
+func (r *Resolver) resolveRegisteredProtoType(log *report.Logger, f *scanner.Field, goType string, info *PackagesInfo) {
+	compatible := compatibleProtoTypes(f.Type)
+	if n, ok := f.Type.(*scanner.Named); ok {
+		switch {
+		case r.isCustomType(n):
+			compatible = scalarProtoTypes
+		case info.IsEnum(n):
+			compatible = enumProtoTypes
+		}
+	}
+
+	if _, ok := compatible[f.ProtoType]; !ok {
+		log.Warn("proto type %q registered for %s is not compatible with %s and will be ignored", f.ProtoType, goType, typeString(f.Type))
+		f.ProtoType = ""
+	}
+}
+
+var (
+	intProtoTypes = protoTypeSet(
+		"int32", "int64", "uint32", "uint64", "sint32", "sint64",
+		"fixed32", "fixed64", "sfixed32", "sfixed64",
+	)
+	floatProtoTypes  = protoTypeSet("float", "double")
+	stringProtoTypes = protoTypeSet("string", "bytes")
+	boolProtoTypes   = protoTypeSet("bool")
+	enumProtoTypes   = protoTypeSet(
+		"int32", "int64", "uint32", "uint64", "sint32", "sint64",
+		"fixed32", "fixed64", "sfixed32", "sfixed64", "string",
+	)
+	scalarProtoTypes = protoTypeSet(
+		"int32", "int64", "uint32", "uint64", "sint32", "sint64",
+		"fixed32", "fixed64", "sfixed32", "sfixed64",
+		"float", "double", "string", "bytes", "bool",
+	)
+)
+
+// compatibleProtoTypes returns the proto scalar types a field of the given
+// type can be forced to.
+func compatibleProtoTypes(t scanner.Type) map[string]struct{} {
+	basic, ok := t.(*scanner.Basic)
+	if !ok {
+		return nil
+	}
+
+	if basic.IsRepeated() {
+		if basic.Name == "byte" || basic.Name == "uint8" {
+			return stringProtoTypes
+		}
+		return nil
+	}
+
+	switch basic.Name {
+	case "int", "int8", "int16", "int32", "int64", "rune",
+		"uint", "uint8", "uint16", "uint32", "uint64", "byte":
+		return intProtoTypes
+	case "float32", "float64":
+		return floatProtoTypes
+	case "string":
+		return stringProtoTypes
+	case "bool":
+		return boolProtoTypes
+	default:
+		return nil
+	}
+}
+
+func protoTypeSet(types ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		set[t] = struct{}{}
+	}
+	return set
+}
+
+func (r *Resolver) resolveType(log *report.Logger, typ scanner.Type, info *PackagesInfo) (result scanner.Type) {
 	switch t := typ.(type) {
 	case *scanner.Named:
 		if r.isCustomType(t) {
 			return t
 		}
 
+		// the type was explicitly ignored, so there is no need to warn
+		if info.IsIgnored(t) {
+			return nil
+		}
+
 		if _, ok := info.Packages[t.Path]; !ok {
-			report.Warn("type %q of package %s will be ignored because it was not present on the scan path", t.Name, t.Path)
+			log.Warn("type %q of package %s will be ignored because it was not present on the scan path", t.Name, t.Path)
 			return nil
 		}
 
@@ -84,8 +395,11 @@ func (r *Resolver) resolveType(typ scanner.Type, info *PackagesInfo) (result sca
 	case *scanner.Basic:
 		result = t
 	case *scanner.Map:
-		t.Key = r.resolveType(t.Key, info)
-		t.Value = r.resolveType(t.Value, info)
+		t.Key = r.resolveType(log, t.Key, info)
+		t.Value = r.resolveType(log, t.Value, info)
+		if t.Key == nil || t.Value == nil {
+			return nil
+		}
 		result = t
 	}
 
@@ -96,18 +410,24 @@ func (r *Resolver) resolveType(typ scanner.Type, info *PackagesInfo) (result sca
 type Packages []*scanner.Package
 
 // Info retrieves some information about a list of packages like the
-// aliases in all of them combined and the paths of all the packages.
+// aliases and ignored types in all of them combined and the paths of all
+// the packages.
 // Note that enums are removed from the aliases as we do not want to
 // think of them as aliases but as named types instead.
 func (pkgs Packages) Info() *PackagesInfo {
 	result := &PackagesInfo{
 		Aliases:  make(map[string]scanner.Type),
 		Packages: make(map[string]struct{}),
+		Ignored:  make(map[string]struct{}),
+		Enums:    pkgs.Enums(),
 	}
-	enums := pkgs.Enums()
+	enums := result.Enums
 
 	for _, p := range pkgs {
 		result.Packages[p.Path] = struct{}{}
+		for n := range p.IgnoredTypes {
+			result.Ignored[n] = struct{}{}
+		}
 		for n, t := range p.Aliases {
 			if _, ok := enums[n]; !ok {
 				result.Aliases[n] = t
@@ -135,6 +455,8 @@ func (pkgs Packages) Enums() map[string]struct{} {
 type PackagesInfo struct {
 	Aliases  map[string]scanner.Type
 	Packages map[string]struct{}
+	Ignored  map[string]struct{}
+	Enums    map[string]struct{}
 }
 
 // AliasOf returns the alias of a given named type or nil if there is
@@ -146,3 +468,15 @@ func (i *PackagesInfo) AliasOf(named *scanner.Named) scanner.Type {
 	}
 	return alias
 }
+
+// IsIgnored reports whether the given named type was explicitly ignored.
+func (i *PackagesInfo) IsIgnored(named *scanner.Named) bool {
+	_, ok := i.Ignored[named.String()]
+	return ok
+}
+
+// IsEnum reports whether the given named type is an enum.
+func (i *PackagesInfo) IsEnum(named *scanner.Named) bool {
+	_, ok := i.Enums[named.String()]
+	return ok
+}
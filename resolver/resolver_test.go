@@ -1,11 +1,14 @@
 package resolver
 
 import (
+	"context"
+	"go/constant"
 	"os"
 	"path/filepath"
 	"sort"
 	"testing"
 
+	"github.com/src-d/proteus/report"
 	"github.com/src-d/proteus/scanner"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -75,6 +78,297 @@ func TestGetPackagesInfo(t *testing.T) {
 	require.True(t, ok)
 }
 
+func TestIsValidMapKey(t *testing.T) {
+	cases := []struct {
+		typ   scanner.Type
+		valid bool
+	}{
+		{scanner.NewBasic("string"), true},
+		{scanner.NewBasic("int32"), true},
+		{scanner.NewBasic("bool"), true},
+		{scanner.NewBasic("float32"), false},
+		{scanner.NewBasic(scanner.Any), false},
+		{repeated(scanner.NewBasic("byte")), false},
+		{scanner.NewNamed("foo", "Bar"), false},
+		{scanner.NewMap(scanner.NewBasic("string"), scanner.NewBasic("string")), false},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.valid, isValidMapKey(c.typ), typeString(c.typ))
+	}
+}
+
+func TestResolveInvalidMapKeys(t *testing.T) {
+	for _, mapEntries := range []bool{false, true} {
+		pkg := &scanner.Package{
+			Path: "foo",
+			Structs: []*scanner.Struct{
+				{
+					Name: "Foo",
+					Fields: []*scanner.Field{
+						{Name: "Valid", Type: scanner.NewMap(scanner.NewBasic("string"), scanner.NewBasic("int"))},
+						{Name: "Float", Type: scanner.NewMap(scanner.NewBasic("float64"), scanner.NewBasic("int"))},
+						{Name: "Unresolved", Type: scanner.NewMap(scanner.NewNamed("bar", "Bar"), scanner.NewBasic("int"))},
+					},
+				},
+			},
+		}
+
+		r := New()
+		r.MapEntries = mapEntries
+		r.Resolve(Packages{pkg})
+
+		foo := pkg.Structs[0]
+		if !mapEntries {
+			require.Equal(t, 1, len(pkg.Structs))
+			require.Equal(t, 1, len(foo.Fields))
+			require.Equal(t, "Valid", foo.Fields[0].Name)
+			continue
+		}
+
+		require.Equal(t, 2, len(pkg.Structs))
+		require.Equal(t, 2, len(foo.Fields))
+		require.Equal(t, "Float", foo.Fields[1].Name)
+		require.Equal(t, repeated(scanner.NewNamed("foo", "FooFloatEntry")), foo.Fields[1].Type)
+
+		entry := pkg.Structs[1]
+		require.Equal(t, "FooFloatEntry", entry.Name)
+		require.Equal(t, []*scanner.Field{
+			{Name: "Key", Type: scanner.NewBasic("float64")},
+			{Name: "Value", Type: scanner.NewBasic("int")},
+		}, entry.Fields)
+	}
+}
+
+func TestResolveMapEntryNameCollision(t *testing.T) {
+	pkg := &scanner.Package{
+		Path: "foo",
+		Structs: []*scanner.Struct{
+			{
+				Name: "Foo",
+				Fields: []*scanner.Field{
+					{Name: "Float", Type: scanner.NewMap(scanner.NewBasic("float64"), scanner.NewBasic("int"))},
+				},
+			},
+			{Name: "FooFloatEntry"},
+		},
+	}
+
+	r := New()
+	r.MapEntries = true
+	r.Resolve(Packages{pkg})
+
+	require.Equal(t, 2, len(pkg.Structs))
+	require.Equal(t, 0, len(pkg.Structs[0].Fields))
+}
+
+func TestResolveMapEntriesNameCollision(t *testing.T) {
+	pkg := &scanner.Package{
+		Path: "foo",
+		Structs: []*scanner.Struct{
+			{
+				Name: "A",
+				Fields: []*scanner.Field{
+					{Name: "BM", Type: scanner.NewMap(scanner.NewBasic("float64"), scanner.NewBasic("int"))},
+				},
+			},
+			{
+				Name: "AB",
+				Fields: []*scanner.Field{
+					{Name: "M", Type: scanner.NewMap(scanner.NewBasic("float64"), scanner.NewBasic("string"))},
+				},
+			},
+		},
+	}
+
+	r := New()
+	r.MapEntries = true
+	r.Resolve(Packages{pkg})
+
+	require.Equal(t, 3, len(pkg.Structs))
+	require.Equal(t, repeated(scanner.NewNamed("foo", "ABMEntry")), pkg.Structs[0].Fields[0].Type)
+	require.Equal(t, 0, len(pkg.Structs[1].Fields), "AB.M would be converted to an entry with the same name")
+	require.Equal(t, "ABMEntry", pkg.Structs[2].Name)
+	require.Equal(t, scanner.NewBasic("int"), pkg.Structs[2].Fields[1].Type)
+}
+
+func TestResolveContextCancelled(t *testing.T) {
+	pkgs := Packages{
+		&scanner.Package{Path: "foo"},
+		&scanner.Package{Path: "bar"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.Equal(t, context.Canceled, New().ResolveContext(ctx, pkgs))
+	for _, p := range pkgs {
+		require.False(t, p.Resolved, p.Path)
+	}
+}
+
+func TestResolveIgnoredTypes(t *testing.T) {
+	pkg := &scanner.Package{
+		Path: "foo",
+		Structs: []*scanner.Struct{
+			{
+				Name: "Foo",
+				Fields: []*scanner.Field{
+					{Name: "A", Type: scanner.NewBasic("int")},
+					{Name: "B", Type: scanner.NewNamed("foo", "Bar")},
+				},
+			},
+		},
+		IgnoredTypes: map[string]struct{}{"foo.Bar": struct{}{}},
+	}
+
+	New().Resolve(Packages{pkg})
+	require.Equal(t, 1, len(pkg.Structs[0].Fields))
+	require.Equal(t, "A", pkg.Structs[0].Fields[0].Name)
+}
+
+func TestResolveEmbeddedTypes(t *testing.T) {
+	pkg := &scanner.Package{
+		Path: "foo",
+		Structs: []*scanner.Struct{
+			{
+				Name: "Cache",
+				Fields: []*scanner.Field{
+					{Name: "Mutex", Type: scanner.NewNamed("sync", "Mutex"), Embedded: true},
+					{Name: "Time", Type: scanner.NewNamed("time", "Time"), Embedded: true},
+					{Name: "Bar", Type: scanner.NewNamed("foo", "Bar"), Embedded: true},
+					{Name: "Name", Type: scanner.NewBasic("string")},
+				},
+			},
+		},
+	}
+
+	var warnings int
+	defer report.AddHook(func(e *report.Entry) {
+		if e.Level >= report.WarnLevel {
+			warnings++
+		}
+	})()
+
+	New().Resolve(Packages{pkg})
+	require.Equal(t, 0, warnings, "unknown embedded types should be ignored silently")
+
+	var names []string
+	for _, f := range pkg.Structs[0].Fields {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"Time", "Bar", "Name"}, names)
+}
+
+func TestResolveProtoType(t *testing.T) {
+	cases := []struct {
+		typ       scanner.Type
+		protoType string
+		expected  string
+	}{
+		{scanner.NewBasic("int"), "uint64", "uint64"},
+		{scanner.NewBasic("int"), "sfixed32", "sfixed32"},
+		{scanner.NewBasic("int"), "string", ""},
+		{scanner.NewBasic("string"), "bytes", "bytes"},
+		{repeated(scanner.NewBasic("byte")), "string", "string"},
+		{repeated(scanner.NewBasic("int")), "uint32", ""},
+		{scanner.NewBasic("float32"), "double", "double"},
+		{scanner.NewBasic("bool"), "int32", ""},
+		{scanner.NewNamed("time", "Time"), "int64", ""},
+		{scanner.NewBasic("int"), "", ""},
+	}
+
+	for _, c := range cases {
+		pkg := &scanner.Package{
+			Path: "foo",
+			Structs: []*scanner.Struct{
+				{
+					Name: "Foo",
+					Fields: []*scanner.Field{
+						{Name: "A", Type: c.typ, ProtoType: c.protoType},
+					},
+				},
+			},
+		}
+
+		New().Resolve(Packages{pkg})
+		require.Equal(t, c.expected, pkg.Structs[0].Fields[0].ProtoType, "%s as %s", typeString(c.typ), c.protoType)
+	}
+}
+
+func TestResolveRegisteredTypes(t *testing.T) {
+	pkg := &scanner.Package{
+		Path: "foo",
+		Aliases: map[string]scanner.Type{
+			"foo.ID": scanner.NewBasic("int64"),
+		},
+		Structs: []*scanner.Struct{
+			{
+				Name: "Foo",
+				Fields: []*scanner.Field{
+					{Name: "ID", Type: scanner.NewNamed("foo", "ID")},
+					{Name: "Count", Type: scanner.NewBasic("int")},
+					{Name: "Forced", Type: scanner.NewBasic("int"), ProtoType: "uint64"},
+					{Name: "Counts", Type: repeated(scanner.NewBasic("int"))},
+					{Name: "Money", Type: scanner.NewNamed("bar", "Money")},
+					{Name: "Timeout", Type: scanner.NewNamed("time", "Duration")},
+					{Name: "Status", Type: scanner.NewNamed("foo", "Status")},
+					{Name: "Bar", Type: scanner.NewNamed("foo", "Bar")},
+				},
+			},
+			{Name: "Bar"},
+		},
+		Enums: []*scanner.Enum{enum("Status", "Active", "Inactive")},
+	}
+
+	var warnings []string
+	defer report.AddHook(func(e *report.Entry) {
+		if e.Level == report.WarnLevel {
+			warnings = append(warnings, e.Message)
+		}
+	})()
+
+	r := New()
+	r.AddCustomType("bar", "Money")
+	require.Nil(t, r.SetProtoType("foo.ID", "fixed64"))
+	require.Nil(t, r.SetProtoType("int", "sint64"))
+	require.NotNil(t, r.SetProtoType("int", "varint"))
+	require.Nil(t, r.SetProtoType("time.Duration", "int64"))
+	require.Nil(t, r.SetProtoType("foo.Status", "string"))
+	require.Nil(t, r.SetProtoType("foo.Bar", "int32"))
+	r.Resolve(Packages{pkg})
+
+	require.Equal(t, []*scanner.Field{
+		{Name: "ID", Type: scanner.NewBasic("int64"), ProtoType: "fixed64"},
+		{Name: "Count", Type: scanner.NewBasic("int"), ProtoType: "sint64"},
+		{Name: "Forced", Type: scanner.NewBasic("int"), ProtoType: "uint64"},
+		{Name: "Counts", Type: repeated(scanner.NewBasic("int"))},
+		{Name: "Money", Type: scanner.NewNamed("bar", "Money")},
+		{Name: "Timeout", Type: scanner.NewNamed("time", "Duration"), ProtoType: "int64"},
+		{Name: "Status", Type: scanner.NewNamed("foo", "Status"), ProtoType: "string"},
+		{Name: "Bar", Type: scanner.NewNamed("foo", "Bar")},
+	}, pkg.Structs[0].Fields)
+
+	require.Equal(t, []string{
+		`proto type "int32" registered for foo.Bar is not compatible with foo.Bar and will be ignored`,
+	}, warnings)
+}
+
+func TestResolveCivilTypes(t *testing.T) {
+	fields := []*scanner.Field{
+		{Name: "Date", Type: scanner.NewNamed("cloud.google.com/go/civil", "Date")},
+		{Name: "Time", Type: scanner.NewNamed("cloud.google.com/go/civil", "Time")},
+		{Name: "DateTime", Type: scanner.NewNamed("cloud.google.com/go/civil", "DateTime")},
+	}
+	pkg := &scanner.Package{
+		Path:    "foo",
+		Structs: []*scanner.Struct{{Name: "Foo", Fields: fields}},
+	}
+
+	New().Resolve(Packages{pkg})
+	require.Equal(t, fields, pkg.Structs[0].Fields)
+}
+
 func TestResolver(t *testing.T) {
 	suite.Run(t, new(ResolverSuite))
 }
@@ -133,6 +427,23 @@ func (s *ResolverSuite) assertStruct(st *scanner.Struct, name string, fields ...
 	}
 }
 
+func BenchmarkResolve(b *testing.B) {
+	sc, err := scanner.New(projectPath("fixtures"), projectPath("fixtures/subpkg"))
+	require.Nil(b, err)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// resolution modifies the packages, so they need to be scanned
+		// again on every iteration
+		b.StopTimer()
+		pkgs, err := sc.Scan()
+		require.Nil(b, err)
+		b.StartTimer()
+
+		New().Resolve(Packages(pkgs))
+	}
+}
+
 func assertStrSet(t *testing.T, set map[string]struct{}, expected ...string) {
 	var vals []string
 	for v := range set {
@@ -143,10 +454,19 @@ func assertStrSet(t *testing.T, set map[string]struct{}, expected ...string) {
 }
 
 func enum(name string, values ...string) *scanner.Enum {
-	return &scanner.Enum{
-		Name:   name,
-		Values: values,
+	e := &scanner.Enum{Name: name}
+	for i, v := range values {
+		e.Values = append(e.Values, &scanner.EnumValue{
+			Name:  v,
+			Value: constant.MakeInt64(int64(i)),
+		})
 	}
+	return e
+}
+
+func repeated(t scanner.Type) scanner.Type {
+	t.SetRepeated(true)
+	return t
 }
 
 func projectPath(pkg string) string {
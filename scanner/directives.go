@@ -0,0 +1,150 @@
+package scanner
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// Directives are comments in the form `//proteus:<directive> [args...]`
+// written right above or next to the type, field or package clause they
+// apply to.
+const directivePrefix = "//proteus:"
+
+const (
+	// ignoreDirective excludes a type or a field from the scan without
+	// reporting any warning about it.
+	ignoreDirective = "ignore"
+	// packageDirective sets the name of the proto package of a Go package,
+	// instead of deriving it from the Go package. It must be written in
+	// the package doc, e.g. `//proteus:package com.acme.billing.v1`.
+	packageDirective = "package"
+)
+
+// directive is a single directive with its arguments.
+type directive struct {
+	name string
+	args []string
+}
+
+// findDirectives returns the directives in the given comment groups.
+func findDirectives(groups ...*ast.CommentGroup) []*directive {
+	var directives []*directive
+	for _, cg := range groups {
+		if cg == nil {
+			continue
+		}
+
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, directivePrefix) {
+				fields := strings.Fields(strings.TrimPrefix(c.Text, directivePrefix))
+				if len(fields) > 0 {
+					directives = append(directives, &directive{fields[0], fields[1:]})
+				}
+			}
+		}
+	}
+	return directives
+}
+
+// findDirective returns the first directive with the given name in the
+// comment groups or nil if there is none.
+func findDirective(name string, groups ...*ast.CommentGroup) *directive {
+	for _, d := range findDirectives(groups...) {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func hasDirective(name string, groups ...*ast.CommentGroup) bool {
+	return findDirective(name, groups...) != nil
+}
+
+var protoPackageRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// findProtoPackage returns the proto package set with the package directive
+// in the doc of any of the files of a package, or an empty string if there
+// is none. It is an error to set it more than once with different values.
+func findProtoPackage(files []*ast.File) (string, error) {
+	var pkg string
+	for _, f := range files {
+		d := findDirective(packageDirective, f.Doc)
+		if d == nil {
+			continue
+		}
+
+		if len(d.args) != 1 || !protoPackageRegex.MatchString(d.args[0]) {
+			return "", fmt.Errorf("invalid %s%s directive, it must be followed by a valid proto package name: %s", directivePrefix, packageDirective, strings.Join(d.args, " "))
+		}
+
+		if pkg != "" && pkg != d.args[0] {
+			return "", fmt.Errorf("conflicting proto packages set with %s%s: %s and %s", directivePrefix, packageDirective, pkg, d.args[0])
+		}
+		pkg = d.args[0]
+	}
+	return pkg, nil
+}
+
+// findIgnored returns the positions of the names of all the types and
+// struct fields with the ignore directive in the given files.
+func findIgnored(files []*ast.File) map[token.Pos]struct{} {
+	ignored := make(map[token.Pos]struct{})
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.GenDecl:
+				for _, spec := range n.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+
+					// the doc of a non grouped declaration belongs to the
+					// declaration, not to the type spec
+					doc := ts.Doc
+					if doc == nil && !n.Lparen.IsValid() {
+						doc = n.Doc
+					}
+
+					if hasDirective(ignoreDirective, doc, ts.Comment) {
+						ignored[ts.Name.Pos()] = struct{}{}
+					}
+				}
+			case *ast.Field:
+				if !hasDirective(ignoreDirective, n.Doc, n.Comment) {
+					break
+				}
+
+				for _, name := range n.Names {
+					ignored[name.Pos()] = struct{}{}
+				}
+
+				if len(n.Names) == 0 {
+					if ident := embeddedFieldIdent(n.Type); ident != nil {
+						ignored[ident.Pos()] = struct{}{}
+					}
+				}
+			}
+			return true
+		})
+	}
+	return ignored
+}
+
+// embeddedFieldIdent returns the identifier the type checker uses as the
+// name of an embedded field, whose position is the position of the field.
+func embeddedFieldIdent(e ast.Expr) *ast.Ident {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e
+	case *ast.StarExpr:
+		return embeddedFieldIdent(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package scanner
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const recursiveSuffix = string(filepath.Separator) + "..."
+
+// ExpandPaths returns the directories of the packages matching the given
+// patterns, which can be used to create a Scanner. A pattern can be:
+//   - a directory;
+//   - a glob pattern, as understood by filepath.Glob, of which only the
+//     matching directories are taken;
+//   - any of the above followed by "/...", which matches the directories and
+//     all the directories below them that contain Go source files, except
+//     for testdata, vendor and the ones starting with "." or "_", like the go
+//     tool does.
+//
+// Directories matching any of the exclude patterns, which follow the same
+// rules, are left out.
+func ExpandPaths(patterns, exclude []string) ([]string, error) {
+	excluded, err := expandPatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[string]struct{}, len(excluded))
+	for _, p := range excluded {
+		skip[p] = struct{}{}
+	}
+
+	paths, err := expandPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, p := range paths {
+		if _, ok := skip[p]; !ok {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// expandPatterns returns the directories matching the patterns in the order
+// they are matched, without duplicates.
+func expandPatterns(patterns []string) ([]string, error) {
+	var (
+		seen   = make(map[string]struct{})
+		result []string
+	)
+
+	for _, pattern := range patterns {
+		recursive := strings.HasSuffix(pattern, recursiveSuffix)
+		pattern = filepath.Clean(strings.TrimSuffix(pattern, recursiveSuffix))
+
+		// paths that are not glob patterns are kept even if they are not
+		// directories, so the scanner can report it
+		matches := []string{pattern}
+		if hasMeta(pattern) {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, m := range matches {
+			if hasMeta(pattern) && !isDir(m) {
+				continue
+			}
+
+			dirs := []string{m}
+			if recursive {
+				var err error
+				if dirs, err = packageDirs(m); err != nil {
+					return nil, err
+				}
+			}
+
+			for _, d := range dirs {
+				if _, ok := seen[d]; !ok {
+					seen[d] = struct{}{}
+					result = append(result, d)
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// packageDirs returns the directories with Go source files in them that are
+// root or below it.
+func packageDirs(root string) ([]string, error) {
+	var dirs []string
+	// the trailing separator makes Walk follow root when it is a symlink
+	err := filepath.Walk(root+string(filepath.Separator), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		path = filepath.Clean(path)
+
+		if !fi.IsDir() {
+			return nil
+		}
+
+		if path != root && isIgnoredDir(fi.Name()) {
+			return filepath.SkipDir
+		}
+
+		if _, err := build.ImportDir(path, 0); err != nil {
+			if _, ok := err.(*build.NoGoError); ok {
+				return nil
+			}
+		}
+
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs, err
+}
+
+func isIgnoredDir(name string) bool {
+	return name == "testdata" ||
+		name == "vendor" ||
+		strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "_")
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -1,15 +1,19 @@
 package scanner
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/build"
+	"go/constant"
 	"go/importer"
 	"go/parser"
 	"go/token"
 	"go/types"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -26,7 +30,18 @@ type Package struct {
 	Structs  []*Struct
 	Enums    []*Enum
 	Aliases  map[string]Type
-	values   map[string][]string
+	// ProtoPackage is the proto package set for this package with the
+	// `//proteus:package` directive, if any.
+	ProtoPackage string
+	// IgnoredTypes contains the full names of the types explicitly excluded
+	// with the `//proteus:ignore` directive.
+	IgnoredTypes map[string]struct{}
+	values       map[string][]*EnumValue
+	ignored      map[token.Pos]struct{}
+	instances    map[string]string
+	unsupported  UnsupportedPolicy
+	errors       []string
+	fset         *token.FileSet
 }
 
 // Type is the common interface for all possible types supported in protogo.
@@ -59,6 +74,10 @@ func (t *BaseType) IsNullable() bool   { return t.Nullable }
 func (t *BaseType) SetRepeated(v bool) { t.Repeated = v }
 func (t *BaseType) SetNullable(v bool) { t.Nullable = v }
 
+// Any is the name of the basic type representing the empty interface, which
+// can hold any value.
+const Any = "any"
+
 // Basic is a basic type, which only is identified by its name.
 type Basic struct {
 	*BaseType
@@ -109,13 +128,51 @@ func NewMap(key, val Type) Type {
 // Enum consists of a list of possible values.
 type Enum struct {
 	Name   string
-	Values []string
+	Values []*EnumValue
+	// Underlying is the name of the basic type the enum is defined on, such
+	// as int or string. Values of enums defined on strings are not numbers,
+	// so they need to be converted by name to and from their proto enum.
+	Underlying string
+}
+
+// IsString reports whether the enum is defined on a string type.
+func (e *Enum) IsString() bool {
+	return e.Underlying == "string"
+}
+
+// EnumValue is a constant of an enum type with its name and its value.
+type EnumValue struct {
+	Name  string
+	Value constant.Value
+}
+
+// HasType reports whether the package has a struct or an enum with the
+// given name.
+func (p *Package) HasType(name string) bool {
+	for _, s := range p.Structs {
+		if s.Name == name {
+			return true
+		}
+	}
+
+	for _, e := range p.Enums {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 // Struct represents a Go struct with its name and fields.
 type Struct struct {
 	Name   string
 	Fields []*Field
+	// Pos is the position of the name of the struct in the source. It is
+	// not valid for structs that have no declaration of their own.
+	Pos token.Position
+	// Omitted contains the names of the fields left out because their type
+	// can not be serialized, when the OmitUnsupported policy is used.
+	Omitted []string
 }
 
 func (s *Struct) HasField(name string) bool {
@@ -131,14 +188,82 @@ func (s *Struct) HasField(name string) bool {
 type Field struct {
 	Name string
 	Type Type
+	// Pos is the position of the field in the source.
+	Pos token.Position
+	// Embedded reports whether the field is an embedded struct with no
+	// exported fields, such as time.Time, which is kept as a regular field
+	// named after its type.
+	Embedded bool
+	// ProtoType is the proto scalar type forced for the field with the
+	// `type=` option of the proto tag. If empty, the proto type is the one
+	// corresponding to Type.
+	ProtoType string
 }
 
+// UnsupportedPolicy is what the scanner does with struct fields whose type
+// can not be serialized at all, such as channels, functions and
+// unsafe.Pointer.
+type UnsupportedPolicy int
+
+const (
+	// SkipUnsupported leaves the fields out and reports a warning.
+	SkipUnsupported UnsupportedPolicy = iota
+	// RejectUnsupported reports an error for each field and makes the
+	// scan of the package fail.
+	RejectUnsupported
+	// OmitUnsupported leaves the fields out without warnings, but keeps
+	// their names in the Omitted list of their struct.
+	OmitUnsupported
+)
+
+// ErrUnsupported is the error a scan fails with when a package has fields
+// whose type can not be serialized and the RejectUnsupported policy is
+// used. The errors returned by Scan wrap it, along with the errors of the
+// other packages, so errors.Is can be used to check for it.
+var ErrUnsupported = errors.New("fields with types that can not be serialized")
+
 // Scanner scans paths looking for Go source files to parse
 // and extract types and structs from.
 type Scanner struct {
 	paths []string
+	Options
+}
+
+// Options configure a Scanner. The zero value scans the files the go tool
+// would build for the current platform, without tests.
+type Options struct {
+	// BuildTags are the additional build tags to take into account when
+	// choosing the files of a package.
+	BuildTags []string
+	// IncludeTests makes the test files of a package that belong to the
+	// package itself be scanned too. External test packages never are.
+	IncludeTests bool
+	// FileFilter, if not nil, is called with the path of every file to
+	// scan, and only the files it returns true for are scanned.
+	FileFilter func(path string) bool
+	// Progress, if not nil, is called every time a package has been
+	// scanned. It is never called concurrently.
+	Progress ProgressFunc
+	// Unsupported is the policy for struct fields whose type can not be
+	// serialized. By default they are skipped with a warning.
+	Unsupported UnsupportedPolicy
+}
+
+// Progress describes how far a scan has gone.
+type Progress struct {
+	// Path is the path of the package that has just been scanned.
+	Path string
+	// Scanned is the number of packages already scanned, including Path.
+	Scanned int
+	// Total is the number of packages to scan.
+	Total int
+	// Err is the error scanning Path, if any.
+	Err error
 }
 
+// ProgressFunc receives the progress of a scan.
+type ProgressFunc func(Progress)
+
 // New creates a new Scanner that will look for types and structs
 // only in the given paths.
 func New(paths ...string) (*Scanner, error) {
@@ -158,53 +283,93 @@ func New(paths ...string) (*Scanner, error) {
 // Scan retrieves the scanned packages containing the extracted
 // go types and structs.
 func (s *Scanner) Scan() ([]*Package, error) {
+	return s.ScanContext(context.Background())
+}
+
+// ScanContext is like Scan, but it stops as soon as the context is done
+// and returns its error. Packages already being type checked at that point
+// are left to finish in the background, but their results are discarded
+// and no more progress is reported.
+func (s *Scanner) ScanContext(ctx context.Context) ([]*Package, error) {
 	var (
-		pkgs   = make([]*Package, len(s.paths))
-		errors []error
-		mut    sync.Mutex
-		wg     = new(sync.WaitGroup)
+		pkgs    = make([]*Package, len(s.paths))
+		errs    []error
+		scanned int
+		mut     sync.Mutex
+		wg      = new(sync.WaitGroup)
+		done    = make(chan struct{})
 	)
 
 	wg.Add(len(s.paths))
 	for i, p := range s.paths {
 		go func(p string, i int) {
 			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
 
 			pkg, err := s.scanPackage(p)
 			mut.Lock()
 			defer mut.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+
 			if err != nil {
-				errors = append(errors, fmt.Errorf("error scanning package %q: %s", p, err))
+				errs = append(errs, fmt.Errorf("error scanning package %q: %w", p, err))
 			} else {
 				pkgs[i] = pkg
 			}
+
+			scanned++
+			if s.Progress != nil {
+				s.Progress(Progress{p, scanned, len(s.paths), err})
+			}
 		}(p, i)
 	}
 
-	wg.Wait()
-	if len(errors) > 0 {
-		var lines []string
-		for _, err := range errors {
-			lines = append(lines, err.Error())
-		}
-		return nil, fmt.Errorf(strings.Join(lines, "\n"))
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// wait for the progress being reported, if any, so none is
+		// reported after returning, as the context is already checked
+		// before reporting it
+		mut.Lock()
+		defer mut.Unlock()
+		return nil, ctx.Err()
+	}
+
+	// the context may be done once all the packages were skipped
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
 
 	return pkgs, nil
 }
 
 func (s *Scanner) scanPackage(path string) (*Package, error) {
-	files, err := getSourceFiles(path)
+	report.With(report.Fields{"package": path}).Debug("scanning package")
+	files, err := s.sourceFiles(path)
 	if err != nil {
 		return nil, err
 	}
 
-	gopkg, err := parseSourceFiles(path, files)
+	fset := token.NewFileSet()
+	gopkg, astFiles, err := parseSourceFiles(fset, path, files)
 	if err != nil {
 		return nil, err
 	}
 
-	return buildPackage(gopkg)
+	return buildPackage(fset, gopkg, astFiles, s.Unsupported)
 }
 
 func (p *Package) processObject(o types.Object) {
@@ -213,64 +378,112 @@ func (p *Package) processObject(o types.Object) {
 		return
 	}
 
-	switch o.(type) {
-	case *types.Var, *types.Const:
+	if _, ok := o.(*types.TypeName); ok && p.isIgnored(o) {
+		p.IgnoredTypes[objName(o)] = struct{}{}
+		return
+	}
+
+	// generic types are only converted when they are instantiated, as the
+	// type of their fields is not known until then
+	if n.TypeParams().Len() > 0 {
+		return
+	}
+
+	switch o := o.(type) {
+	case *types.Const:
 		if _, ok := n.Underlying().(*types.Basic); ok {
-			p.processEnumValue(o.Name(), n)
+			p.processEnumValue(o, n)
 		}
 		return
+	case *types.Var:
+		return
 	}
 
 	if s, ok := n.Underlying().(*types.Struct); ok {
 
-		st := processStruct(&Struct{Name: o.Name()}, s)
+		st := p.processStruct(&Struct{Name: o.Name(), Pos: p.position(o.Pos())}, s)
 		p.Structs = append(p.Structs, st)
 		return
 	}
 
-	p.Aliases[objName(n.Obj())] = processType(n.Underlying())
+	p.Aliases[objName(n.Obj())] = processType(p.typeLogger(o), n.Underlying())
 }
 
-func processType(typ types.Type) (t Type) {
-	switch u := typ.(type) {
+// processType converts the given Go type, reporting with the given logger
+// why it is ignored if it is not supported.
+func processType(log *report.Logger, typ types.Type) (t Type) {
+	// aliases, including any, are converted as the types they stand for
+	switch u := types.Unalias(typ).(type) {
 	case *types.Named:
+		// the only named type without a package a field can have is the
+		// predeclared error, which is a non-empty interface
+		if u.Obj().Pkg() == nil {
+			log.Warn("ignoring unsupported non-empty interface type %s", typ)
+			return nil
+		}
+
+		name, ok := instanceName(u)
+		if !ok {
+			log.Warn("ignoring unsupported generic type instantiation %s", typ)
+			return nil
+		}
+
 		t = NewNamed(
 			u.Obj().Pkg().Path(),
-			u.Obj().Name(),
+			name,
 		)
 	case *types.Basic:
 		t = NewBasic(u.Name())
 	case *types.Slice:
-		t = processType(u.Elem())
-		t.SetRepeated(true)
+		if t = processType(log, u.Elem()); t != nil {
+			t.SetRepeated(true)
+		}
 	case *types.Array:
-		t = processType(u.Elem())
-		t.SetRepeated(true)
+		if t = processType(log, u.Elem()); t != nil {
+			t.SetRepeated(true)
+		}
 	case *types.Pointer:
-		t = processType(u.Elem())
+		t = processType(log, u.Elem())
 	case *types.Map:
-		key := processType(u.Key())
-		val := processType(u.Elem())
+		key := processType(log, u.Key())
+		val := processType(log, u.Elem())
+		if key == nil || val == nil {
+			return nil
+		}
 		t = NewMap(key, val)
+	case *types.Interface:
+		// only the empty interface is supported, as it can hold any
+		// value, and it is represented as the basic type Any
+		if !u.Empty() {
+			log.Warn("ignoring unsupported non-empty interface type %s", typ)
+			return nil
+		}
+		t = NewBasic(Any)
 	default:
-		report.Warn("ignoring type %s", typ.String())
+		log.Warn("ignoring unsupported type %s", typ)
 		return nil
 	}
 
 	return
 }
 
-func (p *Package) processEnumValue(name string, named *types.Named) {
+// processEnumValue adds the constant to the values of its type. The value is
+// the one computed by the type checker, so values skipped with blank
+// identifiers or computed with expressions using iota are correct.
+func (p *Package) processEnumValue(c *types.Const, named *types.Named) {
 	typ := objName(named.Obj())
-	p.values[typ] = append(p.values[typ], name)
+	p.values[typ] = append(p.values[typ], &EnumValue{
+		Name:  c.Name(),
+		Value: c.Val(),
+	})
 }
 
-func processStruct(s *Struct, elem *types.Struct) *Struct {
+func (p *Package) processStruct(s *Struct, elem *types.Struct) *Struct {
 	for i := 0; i < elem.NumFields(); i++ {
 		v := elem.Field(i)
 		tags := findProtoTags(elem.Tag(i))
 
-		if isIgnoredField(v, tags) {
+		if isIgnoredField(v, tags) || p.isIgnored(v) {
 			continue
 		}
 
@@ -280,34 +493,267 @@ func processStruct(s *Struct, elem *types.Struct) *Struct {
 		// completely ignored and a warning is printed to give
 		// feedback to the user.
 		if s.HasField(v.Name()) {
-			report.Warn("struct %q already has a field %q", s.Name, v.Name())
+			p.fieldLogger(s, v).Warn("struct already has a field with the same name")
+			continue
+		}
+
+		if isUnserializable(v.Type()) {
+			p.processUnsupported(s, v)
 			continue
 		}
 
+		// Embedded structs without exported fields, such as time.Time,
+		// would not promote any field, so they are kept as a regular field
+		// named after the type, the same way Go names them.
 		if v.Anonymous() {
 			embedded := findStruct(v.Type())
 			if embedded == nil {
-				report.Warn("field %q with type %q is not a valid embedded type", v.Name(), v.Type())
-			} else {
-				s = processStruct(s, embedded)
+				p.fieldLogger(s, v).Warn("type %q is not a valid embedded type", v.Type())
+				continue
+			}
+
+			if hasExportedFields(embedded) {
+				s = p.processStruct(s, embedded)
+				continue
 			}
+		}
+
+		if hasStructKey(v.Type()) {
+			p.fieldLogger(s, v).Error("field will be ignored because map key type %s is a struct, which is not valid in protobuf. Use a string or integral key instead, or a named struct type with map entries enabled", v.Type().Underlying().(*types.Map).Key())
 			continue
 		}
 
+		log := p.fieldLogger(s, v)
 		f := &Field{
-			Name: v.Name(),
-			Type: processType(v.Type()),
+			Name:      v.Name(),
+			Type:      processType(log, v.Type()),
+			Pos:       p.position(v.Pos()),
+			ProtoType: findProtoType(tags),
+			Embedded:  v.Anonymous(),
 		}
 		if f.Type == nil {
 			continue
 		}
 
+		if !p.processInstances(log, v.Type()) {
+			continue
+		}
+
 		s.Fields = append(s.Fields, f)
 	}
 
 	return s
 }
 
+// processUnsupported handles a field whose type can not be serialized
+// according to the policy of the package.
+func (p *Package) processUnsupported(s *Struct, v *types.Var) {
+	switch p.unsupported {
+	case RejectUnsupported:
+		p.errors = append(p.errors, fmt.Sprintf("%s: field %s.%s has type %s, which can not be serialized", p.position(v.Pos()), s.Name, v.Name(), v.Type()))
+	case OmitUnsupported:
+		s.Omitted = append(s.Omitted, v.Name())
+	default:
+		p.fieldLogger(s, v).Warn("field will be ignored because its type %s can not be serialized", v.Type())
+	}
+}
+
+// isUnserializable reports whether the type is or contains a channel, a
+// function or an unsafe.Pointer.
+func isUnserializable(t types.Type) bool {
+	switch u := t.Underlying().(type) {
+	case *types.Chan, *types.Signature:
+		return true
+	case *types.Basic:
+		return u.Kind() == types.UnsafePointer
+	case *types.Pointer:
+		return isUnserializable(u.Elem())
+	case *types.Slice:
+		return isUnserializable(u.Elem())
+	case *types.Array:
+		return isUnserializable(u.Elem())
+	case *types.Map:
+		return isUnserializable(u.Key()) || isUnserializable(u.Elem())
+	}
+	return false
+}
+
+// processInstances adds to the package the instantiations of generic types
+// found in the given type, as they do not have a declaration of their own.
+// Each instantiation is added once, as a struct or an alias named after the
+// generic type and its type arguments, e.g. ListUser for List[User]. Only
+// instantiations of generic types declared in the package are supported.
+// It reports false, after logging why, if the field with the type has to be
+// ignored.
+func (p *Package) processInstances(log *report.Logger, typ types.Type) bool {
+	switch u := types.Unalias(typ).(type) {
+	case *types.Pointer:
+		return p.processInstances(log, u.Elem())
+	case *types.Slice:
+		return p.processInstances(log, u.Elem())
+	case *types.Array:
+		return p.processInstances(log, u.Elem())
+	case *types.Map:
+		return p.processInstances(log, u.Key()) && p.processInstances(log, u.Elem())
+	case *types.Named:
+		if u.TypeArgs().Len() == 0 {
+			return true
+		}
+
+		if u.Obj().Pkg() == nil || u.Obj().Pkg().Path() != p.Path {
+			log.Warn("field will be ignored because the instantiation %s of a generic type declared in another package is not supported", u)
+			return false
+		}
+
+		// instances are identified by their full type, as type arguments
+		// from different packages can have the same name
+		name, _ := instanceName(u)
+		full := instanceKey(u)
+		if other, ok := p.instances[name]; ok {
+			if other == full {
+				return true
+			}
+
+			log.Error("field will be ignored because %s can not be converted to the message %s, it is already used for %s", full, name, other)
+			return false
+		}
+
+		if obj, ok := u.Obj().Pkg().Scope().Lookup(name).(*types.TypeName); ok {
+			log.Error("field will be ignored because %s can not be converted to the message %s, there is already a type with that name", full, obj.Name())
+			return false
+		}
+		p.instances[name] = full
+
+		for i := 0; i < u.TypeArgs().Len(); i++ {
+			if !p.processInstances(log, u.TypeArgs().At(i)) {
+				delete(p.instances, name)
+				return false
+			}
+		}
+
+		if st, ok := u.Underlying().(*types.Struct); ok {
+			p.Structs = append(p.Structs, p.processStruct(&Struct{Name: name}, st))
+		} else if t := processType(log, u.Underlying()); t != nil {
+			p.Aliases[p.Path+"."+name] = t
+		}
+	}
+	return true
+}
+
+// instanceKey returns the full name of a type used as or in a type argument.
+// Pointers are left out, as they are converted the same way as the types
+// they point to, e.g. List[*User] and List[User] are the same message.
+func instanceKey(t types.Type) string {
+	switch u := types.Unalias(t).(type) {
+	case *types.Pointer:
+		return instanceKey(u.Elem())
+	case *types.Slice:
+		return "[]" + instanceKey(u.Elem())
+	case *types.Basic:
+		return basicName(u)
+	case *types.Named:
+		if u.TypeArgs().Len() == 0 {
+			return types.TypeString(u, nil)
+		}
+
+		var args []string
+		for i := 0; i < u.TypeArgs().Len(); i++ {
+			args = append(args, instanceKey(u.TypeArgs().At(i)))
+		}
+		return objName(u.Obj()) + "[" + strings.Join(args, ", ") + "]"
+	}
+	return types.TypeString(t, nil)
+}
+
+// instanceName returns the name of a named type, which for instantiations
+// of generic types is made of the name of the generic type followed by the
+// names of its type arguments, e.g. PairStringUser for Pair[string, User].
+// It reports false if any of the type arguments can not be named.
+func instanceName(n *types.Named) (string, bool) {
+	name := n.Obj().Name()
+	for i := 0; i < n.TypeArgs().Len(); i++ {
+		arg, ok := typeArgName(n.TypeArgs().At(i))
+		if !ok {
+			return "", false
+		}
+		name += arg
+	}
+	return name, true
+}
+
+func typeArgName(t types.Type) (string, bool) {
+	switch u := types.Unalias(t).(type) {
+	case *types.Named:
+		return instanceName(u)
+	case *types.Basic:
+		name := basicName(u)
+		return strings.ToUpper(name[:1]) + name[1:], true
+	case *types.Pointer:
+		return typeArgName(u.Elem())
+	case *types.Slice:
+		name, ok := typeArgName(u.Elem())
+		return name + "List", ok
+	}
+	return "", false
+}
+
+// basicName returns the name of the basic type by its kind, so aliases of
+// the same type such as byte and uint8 have the same name.
+func basicName(b *types.Basic) string {
+	return types.Typ[b.Kind()].Name()
+}
+
+// hasStructKey reports whether the type is a map whose key is an unnamed
+// struct, which can not be represented at all, not even as a message.
+func hasStructKey(t types.Type) bool {
+	m, ok := t.Underlying().(*types.Map)
+	if !ok {
+		return false
+	}
+
+	_, ok = m.Key().(*types.Struct)
+	return ok
+}
+
+func hasExportedFields(s *types.Struct) bool {
+	for i := 0; i < s.NumFields(); i++ {
+		if s.Field(i).Exported() {
+			return true
+		}
+	}
+	return false
+}
+
+// typeLogger returns a logger for messages about the declaration of the
+// given type.
+func (p *Package) typeLogger(o types.Object) *report.Logger {
+	fields := report.Fields{"package": p.Path, "type": o.Name()}
+	if pos := p.position(o.Pos()); pos.IsValid() {
+		fields["pos"] = pos.String()
+	}
+	return report.With(fields)
+}
+
+func (p *Package) fieldLogger(s *Struct, v *types.Var) *report.Logger {
+	fields := report.Fields{"struct": s.Name, "field": v.Name()}
+	if v.Pkg() != nil {
+		fields["package"] = v.Pkg().Path()
+	}
+	if pos := p.position(v.Pos()); pos.IsValid() {
+		fields["pos"] = pos.String()
+	}
+	return report.With(fields)
+}
+
+// position returns the position in the source of the given pos, which is
+// not valid if the package was not built from source.
+func (p *Package) position(pos token.Pos) token.Position {
+	if p.fset == nil {
+		return token.Position{}
+	}
+	return p.fset.Position(pos)
+}
+
 func findStruct(t types.Type) *types.Struct {
 	switch elem := t.(type) {
 	case *types.Pointer:
@@ -321,19 +767,59 @@ func findStruct(t types.Type) *types.Struct {
 	}
 }
 
+// collectEnums turns the aliases with constants of their type into enums.
+// The constants are taken from the whole package scope, so an enum can have
+// its values spread across several const blocks and files. Enums are sorted
+// by name so the result does not depend on the order of the files.
 func (p *Package) collectEnums() {
+	var names []string
 	for k := range p.Aliases {
-		if vals, ok := p.values[k]; ok {
-			idx := strings.LastIndex(k, ".")
-			name := k[idx+1:]
+		if _, ok := p.values[k]; ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
 
-			p.Enums = append(p.Enums, &Enum{
-				Name:   name,
-				Values: vals,
-			})
+	for _, k := range names {
+		vals := p.values[k]
+		idx := strings.LastIndex(k, ".")
+		name := k[idx+1:]
 
-			delete(p.Aliases, k)
+		var underlying string
+		if b, ok := p.Aliases[k].(*Basic); ok {
+			underlying = b.Name
 		}
+
+		sort.Sort(byValue(vals))
+		p.Enums = append(p.Enums, &Enum{
+			Name:       name,
+			Values:     vals,
+			Underlying: underlying,
+		})
+
+		delete(p.Aliases, k)
+	}
+}
+
+// byValue sorts enum values by their value and then by their name.
+type byValue []*EnumValue
+
+func (v byValue) Len() int      { return len(v) }
+func (v byValue) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byValue) Less(i, j int) bool {
+	a, b := v[i].Value, v[j].Value
+	if isOrdered(a) && a.Kind() == b.Kind() && !constant.Compare(a, token.EQL, b) {
+		return constant.Compare(a, token.LSS, b)
+	}
+	return v[i].Name < v[j].Name
+}
+
+func isOrdered(v constant.Value) bool {
+	switch v.Kind() {
+	case constant.Int, constant.Float, constant.String:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -341,20 +827,41 @@ func isIgnoredField(f *types.Var, tags []string) bool {
 	return !f.Exported() || (len(tags) > 0 && tags[0] == "-")
 }
 
-func buildPackage(gopkg *types.Package) (*Package, error) {
+// isIgnored reports whether the object has the ignore directive.
+func (p *Package) isIgnored(o types.Object) bool {
+	_, ok := p.ignored[o.Pos()]
+	return ok
+}
+
+func buildPackage(fset *token.FileSet, gopkg *types.Package, files []*ast.File, unsupported UnsupportedPolicy) (*Package, error) {
 	objs := objectsInScope(gopkg.Scope())
 
+	protoPkg, err := findProtoPackage(files)
+	if err != nil {
+		return nil, err
+	}
+
 	pkg := &Package{
-		Path:    gopkg.Path(),
-		Name:    gopkg.Name(),
-		values:  make(map[string][]string),
-		Aliases: make(map[string]Type),
+		Path:         gopkg.Path(),
+		Name:         gopkg.Name(),
+		ProtoPackage: protoPkg,
+		values:       make(map[string][]*EnumValue),
+		Aliases:      make(map[string]Type),
+		IgnoredTypes: make(map[string]struct{}),
+		ignored:      findIgnored(files),
+		instances:    make(map[string]string),
+		unsupported:  unsupported,
+		fset:         fset,
 	}
 
 	for _, o := range objs {
 		pkg.processObject(o)
 	}
 
+	if len(pkg.errors) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, strings.Join(pkg.errors, "; "))
+	}
+
 	pkg.collectEnums()
 	return pkg, nil
 }
@@ -366,8 +873,13 @@ func objectsInScope(scope *types.Scope) (objs []types.Object) {
 	return
 }
 
-func getSourceFiles(path string) ([]string, error) {
-	pkg, err := build.ImportDir(path, 0)
+// sourceFiles returns the paths of the files of the package in the given
+// directory that have to be scanned according to the options.
+func (o *Options) sourceFiles(path string) ([]string, error) {
+	ctx := build.Default
+	ctx.BuildTags = append(ctx.BuildTags[:len(ctx.BuildTags):len(ctx.BuildTags)], o.BuildTags...)
+
+	pkg, err := ctx.ImportDir(path, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -375,34 +887,49 @@ func getSourceFiles(path string) ([]string, error) {
 	var filenames []string
 	filenames = append(filenames, pkg.GoFiles...)
 	filenames = append(filenames, pkg.CgoFiles...)
-
-	if len(filenames) == 0 {
-		return nil, fmt.Errorf("no go source files in path: %s", path)
+	if o.IncludeTests {
+		filenames = append(filenames, pkg.TestGoFiles...)
 	}
 
 	var paths []string
 	for _, f := range filenames {
-		paths = append(paths, filepath.Join(path, f))
+		p := filepath.Join(path, f)
+		if o.FileFilter == nil || o.FileFilter(p) {
+			paths = append(paths, p)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no go source files in path: %s", path)
 	}
 
 	return paths, nil
 }
 
-func parseSourceFiles(root string, paths []string) (*types.Package, error) {
+func parseSourceFiles(fs *token.FileSet, root string, paths []string) (*types.Package, []*ast.File, error) {
 	var files []*ast.File
-	fs := token.NewFileSet()
 	for _, p := range paths {
-		f, err := parser.ParseFile(fs, p, nil, 0)
+		f, err := parser.ParseFile(fs, p, nil, parser.ParseComments)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		files = append(files, f)
 	}
 
-	config := types.Config{Importer: importer.For("gc", nil)}
+	// only declarations are needed to extract types, so function bodies
+	// are not type checked
+	config := types.Config{
+		Importer:         importer.For("gc", nil),
+		IgnoreFuncBodies: true,
+	}
+
+	pkg, err := config.Check(root, fs, files, new(types.Info))
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return config.Check(root, fs, files, new(types.Info))
+	return pkg, files, nil
 }
 
 func objName(obj types.Object) string {
@@ -1,13 +1,21 @@
 package scanner
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/src-d/proteus/report"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,7 +24,7 @@ var gopath = os.Getenv("GOPATH")
 const project = "github.com/src-d/proteus"
 
 func TestGetSourceFiles(t *testing.T) {
-	paths, err := getSourceFiles(projectPath("fixtures"))
+	paths, err := new(Options).sourceFiles(projectPath("fixtures"))
 	require.Nil(t, err)
 	expected := []string{
 		projectPath("fixtures/bar.go"),
@@ -26,22 +34,161 @@ func TestGetSourceFiles(t *testing.T) {
 	require.Equal(t, expected, paths)
 }
 
+func TestSourceFilesOptions(t *testing.T) {
+	dir := writeSource(t, map[string]string{
+		"foo.go":      "package foo\n",
+		"gen.go":      "package foo\n",
+		"tagged.go":   "//go:build extra\n\npackage foo\n",
+		"foo_test.go": "package foo\n",
+		"ext_test.go": "package foo_test\n",
+	})
+
+	cases := []struct {
+		name     string
+		opts     Options
+		expected []string
+	}{
+		{"defaults", Options{}, []string{"foo.go", "gen.go"}},
+		{"build tags", Options{BuildTags: []string{"extra"}}, []string{"foo.go", "gen.go", "tagged.go"}},
+		{"tests", Options{IncludeTests: true}, []string{"foo.go", "gen.go", "foo_test.go"}},
+		{
+			"file filter",
+			Options{FileFilter: func(path string) bool {
+				return filepath.Base(path) != "gen.go"
+			}},
+			[]string{"foo.go"},
+		},
+	}
+
+	for _, c := range cases {
+		paths, err := c.opts.sourceFiles(dir)
+		require.Nil(t, err, c.name)
+
+		var names []string
+		for _, p := range paths {
+			names = append(names, filepath.Base(p))
+		}
+		require.Equal(t, c.expected, names, c.name)
+	}
+
+	_, err := (&Options{FileFilter: func(string) bool { return false }}).sourceFiles(dir)
+	require.NotNil(t, err, "no files left")
+}
+
 func projectPath(pkg string) string {
 	return filepath.Join(gopath, "src", project, pkg)
 }
 
+// writeSource writes the given files, by name, to a temporary directory
+// and returns its path.
+func writeSource(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0644))
+	}
+	return dir
+}
+
+// scanSource scans the package made of the given files.
+func scanSource(t *testing.T, files map[string]string) *Package {
+	scanner, err := New(writeSource(t, files))
+	require.Nil(t, err)
+
+	pkgs, err := scanner.Scan()
+	require.Nil(t, err)
+	return pkgs[0]
+}
+
+// captureReports makes everything reported until the end of the test be
+// written to the returned buffer.
+func captureReports(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	report.SetOutput(&buf)
+	t.Cleanup(func() { report.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestExpandPaths(t *testing.T) {
+	cases := []struct {
+		name     string
+		patterns []string
+		exclude  []string
+		expected []string
+	}{
+		{
+			"plain directories",
+			[]string{projectPath("fixtures"), projectPath("fixtures/subpkg")},
+			nil,
+			[]string{projectPath("fixtures"), projectPath("fixtures/subpkg")},
+		},
+		{
+			"recursive",
+			[]string{projectPath("fixtures/...")},
+			nil,
+			[]string{projectPath("fixtures"), projectPath("fixtures/subpkg")},
+		},
+		{
+			"recursive with exclusion",
+			[]string{projectPath("fixtures/...")},
+			[]string{projectPath("fixtures/sub*")},
+			[]string{projectPath("fixtures")},
+		},
+		{
+			"glob only matches directories",
+			[]string{projectPath("fixtures/*")},
+			nil,
+			[]string{projectPath("fixtures/subpkg")},
+		},
+		{
+			"duplicates",
+			[]string{projectPath("fixtures/subpkg"), projectPath("fixtures/...")},
+			nil,
+			[]string{projectPath("fixtures/subpkg"), projectPath("fixtures")},
+		},
+		{
+			"non existent directory",
+			[]string{projectPath("fixtures/nope")},
+			nil,
+			[]string{projectPath("fixtures/nope")},
+		},
+	}
+
+	for _, c := range cases {
+		paths, err := ExpandPaths(c.patterns, c.exclude)
+		require.Nil(t, err, c.name)
+		require.Equal(t, c.expected, paths, c.name)
+	}
+}
+
 func TestParseSourceFiles(t *testing.T) {
 	paths := []string{
 		projectPath("fixtures/bar.go"),
 		projectPath("fixtures/foo.go"),
 	}
 
-	pkg, err := parseSourceFiles(projectPath("fixtures"), paths)
+	pkg, _, err := parseSourceFiles(token.NewFileSet(), projectPath("fixtures"), paths)
 	require.Nil(t, err)
 
 	require.Equal(t, "foo", pkg.Name())
 }
 
+func TestParseSourceFilesIgnoresFuncBodies(t *testing.T) {
+	dir := writeSource(t, map[string]string{"foo.go": `package foo
+
+type Foo struct {
+	A int
+}
+
+func (f *Foo) Bar() {
+	var x string = f.A
+}
+`})
+
+	pkg, _, err := parseSourceFiles(token.NewFileSet(), dir, []string{filepath.Join(dir, "foo.go")})
+	require.Nil(t, err)
+	require.NotNil(t, pkg.Scope().Lookup("Foo"))
+}
+
 func TestProcessType(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -78,6 +225,21 @@ func TestProcessType(t *testing.T) {
 			types.NewPointer(newNamed("/foo/bar", "Bar", nil)),
 			NewNamed("/foo/bar", "Bar"),
 		},
+		{
+			"slice of pointers to named",
+			types.NewSlice(types.NewPointer(newNamed("/foo/bar", "Bar", nil))),
+			repeated(NewNamed("/foo/bar", "Bar")),
+		},
+		{
+			"array of pointers to basic",
+			types.NewArray(types.NewPointer(types.Typ[types.Int]), 4),
+			repeated(NewBasic("int")),
+		},
+		{
+			"slice of pointers to pointers",
+			types.NewSlice(types.NewPointer(types.NewPointer(newNamed("/foo/bar", "Bar", nil)))),
+			repeated(NewNamed("/foo/bar", "Bar")),
+		},
 		{
 			"map of basic and named",
 			types.NewMap(
@@ -95,14 +257,59 @@ func TestProcessType(t *testing.T) {
 			nil,
 		},
 		{
-			"interface",
+			"empty interface",
 			types.NewInterface(nil, nil),
+			NewBasic(Any),
+		},
+		{
+			"slice of empty interface",
+			types.NewSlice(types.NewInterface(nil, nil)),
+			repeated(NewBasic(Any)),
+		},
+		{
+			"map of empty interface",
+			types.NewMap(types.Typ[types.String], types.NewInterface(nil, nil)),
+			NewMap(NewBasic("string"), NewBasic(Any)),
+		},
+		{
+			"non empty interface",
+			types.NewInterfaceType(
+				[]*types.Func{
+					types.NewFunc(token.NoPos, nil, "Foo", types.NewSignature(nil, nil, nil, false)),
+				},
+				nil,
+			).Complete(),
+			nil,
+		},
+		{
+			"error",
+			types.Universe.Lookup("error").Type(),
+			nil,
+		},
+		{
+			"slice of errors",
+			types.NewSlice(types.Universe.Lookup("error").Type()),
+			nil,
+		},
+		{
+			"map of errors",
+			types.NewMap(types.Typ[types.String], types.Universe.Lookup("error").Type()),
+			nil,
+		},
+		{
+			"slice of unsupported type",
+			types.NewSlice(types.NewChan(types.SendRecv, types.Typ[types.Int])),
+			nil,
+		},
+		{
+			"map of unsupported type",
+			types.NewMap(types.Typ[types.String], types.NewChan(types.SendRecv, types.Typ[types.Int])),
 			nil,
 		},
 	}
 
 	for _, c := range cases {
-		require.Equal(t, c.expected, processType(c.typ), c.name)
+		require.Equal(t, c.expected, processType(report.With(nil), c.typ), c.name)
 	}
 }
 
@@ -123,8 +330,8 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Foo", NewBasic("int")},
-					{"Bar", NewBasic("string")},
+					{Name: "Foo", Type: NewBasic("int")},
+					{Name: "Bar", Type: NewBasic("string")},
 				},
 			},
 		},
@@ -139,7 +346,7 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Foo", NewBasic("int")},
+					{Name: "Foo", Type: NewBasic("int")},
 				},
 			},
 		},
@@ -154,7 +361,23 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Foo", NewBasic("int")},
+					{Name: "Foo", Type: NewBasic("int")},
+				},
+			},
+		},
+		{
+			"struct with forced proto type",
+			types.NewStruct(
+				[]*types.Var{
+					mkField("Foo", types.Typ[types.Int], false),
+					mkField("Bar", types.Typ[types.String], false),
+				},
+				[]string{`proto:"type=uint64"`, `json:"bar" proto:"foo, type=bytes"`},
+			),
+			&Struct{
+				Fields: []*Field{
+					{Name: "Foo", Type: NewBasic("int"), ProtoType: "uint64"},
+					{Name: "Bar", Type: NewBasic("string"), ProtoType: "bytes"},
 				},
 			},
 		},
@@ -169,7 +392,7 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Foo", NewBasic("int")},
+					{Name: "Foo", Type: NewBasic("int")},
 				},
 			},
 		},
@@ -194,9 +417,9 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Foo", NewBasic("int")},
-					{"Bar", NewBasic("string")},
-					{"Baz", NewBasic("uint64")},
+					{Name: "Foo", Type: NewBasic("int")},
+					{Name: "Bar", Type: NewBasic("string")},
+					{Name: "Baz", Type: NewBasic("uint64")},
 				},
 			},
 		},
@@ -221,8 +444,8 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Foo", NewBasic("int")},
-					{"Bar", NewBasic("string")},
+					{Name: "Foo", Type: NewBasic("int")},
+					{Name: "Bar", Type: NewBasic("string")},
 				},
 			},
 		},
@@ -249,9 +472,84 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Foo", NewBasic("int")},
-					{"Bar", NewBasic("string")},
-					{"Baz", NewBasic("uint64")},
+					{Name: "Foo", Type: NewBasic("int")},
+					{Name: "Bar", Type: NewBasic("string")},
+					{Name: "Baz", Type: NewBasic("uint64")},
+				},
+			},
+		},
+		{
+			"embedded struct without exported fields",
+			types.NewStruct(
+				[]*types.Var{
+					mkField("Time",
+						newNamed("time", "Time", types.NewStruct(
+							[]*types.Var{
+								mkField("wall", types.Typ[types.Uint64], false),
+							},
+							nil,
+						),
+						),
+						true,
+					),
+					mkField("Baz", types.Typ[types.Uint64], false),
+				},
+				nil,
+			),
+			&Struct{
+				Fields: []*Field{
+					{Name: "Time", Type: NewNamed("time", "Time"), Embedded: true},
+					{Name: "Baz", Type: NewBasic("uint64")},
+				},
+			},
+		},
+		{
+			"embedded pointer to struct without exported fields",
+			types.NewStruct(
+				[]*types.Var{
+					mkField("Time",
+						types.NewPointer(
+							newNamed("time", "Time", types.NewStruct(
+								[]*types.Var{
+									mkField("wall", types.Typ[types.Uint64], false),
+								},
+								nil,
+							),
+							),
+						),
+						true,
+					),
+				},
+				nil,
+			),
+			&Struct{
+				Fields: []*Field{
+					{Name: "Time", Type: NewNamed("time", "Time"), Embedded: true},
+				},
+			},
+		},
+		{
+			"embedded mutex",
+			types.NewStruct(
+				[]*types.Var{
+					mkField("Mutex",
+						newNamed("sync", "Mutex", types.NewStruct(
+							[]*types.Var{
+								mkField("state", types.Typ[types.Int32], false),
+							},
+							nil,
+						),
+						),
+						true,
+					),
+					mkField("Name", types.Typ[types.String], false),
+				},
+				nil,
+			),
+			&Struct{
+				Fields: []*Field{
+					{Name: "Mutex", Type: NewNamed("sync", "Mutex"), Embedded: true},
+					{Name: "Name", Type: NewBasic("string")},
 				},
 			},
 		},
@@ -266,14 +564,14 @@ func TestProcessStruct(t *testing.T) {
 			),
 			&Struct{
 				Fields: []*Field{
-					{"Baz", NewBasic("uint64")},
+					{Name: "Baz", Type: NewBasic("uint64")},
 				},
 			},
 		},
 	}
 
 	for _, c := range cases {
-		require.Equal(t, c.expected, processStruct(&Struct{}, c.elem), c.name)
+		require.Equal(t, c.expected, new(Package).processStruct(&Struct{}, c.elem), c.name)
 	}
 }
 
@@ -304,11 +602,488 @@ func TestScanner(t *testing.T) {
 	require.Equal(1, len(pkg.Enums), "pkg enums")
 	require.Equal("Baz", pkg.Enums[0].Name)
 
-	require.Equal(
-		[]string{"ABaz", "BBaz", "CBaz", "DBaz"},
-		pkg.Enums[0].Values,
-		"enum values",
-	)
+	for i, name := range []string{"ABaz", "BBaz", "CBaz", "DBaz"} {
+		v := pkg.Enums[0].Values[i]
+		require.Equal(name, v.Name, "enum value name")
+		require.Equal(constant.MakeInt64(int64(i)), v.Value, "enum value %s", name)
+	}
+}
+
+func TestScannerProgress(t *testing.T) {
+	scanner, err := New(projectPath("fixtures"), projectPath("fixtures/subpkg"))
+	require.Nil(t, err)
+
+	var progress []Progress
+	scanner.Progress = func(p Progress) {
+		progress = append(progress, p)
+	}
+
+	_, err = scanner.Scan()
+	require.Nil(t, err)
+
+	require.Equal(t, 2, len(progress))
+	for i, p := range progress {
+		require.Equal(t, i+1, p.Scanned)
+		require.Equal(t, 2, p.Total)
+		require.Nil(t, p.Err)
+	}
+	require.NotEqual(t, progress[0].Path, progress[1].Path)
+}
+
+func TestScanContextCancelled(t *testing.T) {
+	scanner, err := New(projectPath("fixtures"), projectPath("fixtures/subpkg"))
+	require.Nil(t, err)
+
+	var scanned int
+	scanner.Progress = func(Progress) {
+		scanned++
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pkgs, err := scanner.ScanContext(ctx)
+	require.Equal(t, context.Canceled, err)
+	require.Nil(t, pkgs)
+	require.Equal(t, 0, scanned)
+}
+
+func TestScanContextCancelledWhileReporting(t *testing.T) {
+	scanner, err := New(projectPath("fixtures"), projectPath("fixtures/subpkg"))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls, finished int
+	scanner.Progress = func(Progress) {
+		calls++
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+		finished++
+	}
+
+	_, err = scanner.ScanContext(ctx)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, 1, calls)
+	require.Equal(t, 1, finished, "progress should not be reported after returning")
+}
+
+func TestScannerIgnoreDirective(t *testing.T) {
+	require := require.New(t)
+
+	pkg := scanSource(t, map[string]string{"foo.go": `//proteus:package acme.foo
+package foo
+
+//proteus:ignore
+type Ignored struct {
+	A int
+}
+
+type Foo struct {
+	A int
+	//proteus:ignore
+	B chan int
+	C int //proteus:ignore
+	D Ignored
+}
+
+type (
+	//proteus:ignore
+	Bar int
+
+	Baz struct {
+		X int
+	}
+)
+`})
+
+	dir := pkg.Path
+	require.Equal("acme.foo", pkg.ProtoPackage)
+	require.Equal(2, len(pkg.Structs))
+	assertStruct(t, pkg.Structs[0], "Baz", "X")
+	assertStruct(t, pkg.Structs[1], "Foo", "A", "D")
+
+	require.Equal(map[string]struct{}{
+		dir + ".Bar":     struct{}{},
+		dir + ".Ignored": struct{}{},
+	}, pkg.IgnoredTypes)
+	_, ok := pkg.Aliases[dir+".Bar"]
+	require.False(ok, "Bar should not be an alias")
+}
+
+func TestScannerPositions(t *testing.T) {
+	require := require.New(t)
+
+	buf := captureReports(t)
+	pkg := scanSource(t, map[string]string{"foo.go": `package foo
+
+type Foo struct {
+	A int
+	B string
+	C chan int
+	D interface{ M() }
+	E error
+	F any
+}
+
+type Handler func() error
+`})
+
+	path := filepath.Join(pkg.Path, "foo.go")
+	foo := pkg.Structs[0]
+	require.Equal(fmt.Sprintf("%s:3:6", path), foo.Pos.String())
+	require.Equal(fmt.Sprintf("%s:4:2", path), foo.Fields[0].Pos.String())
+	require.Equal(fmt.Sprintf("%s:5:2", path), foo.Fields[1].Pos.String())
+	require.Contains(buf.String(), fmt.Sprintf("pos=%s:6:2", path))
+	require.Contains(buf.String(), fmt.Sprintf("WARN: ignoring unsupported non-empty interface type interface{M()} field=D package=%s pos=%s:7:2 struct=Foo", pkg.Path, path))
+	require.Contains(buf.String(), fmt.Sprintf("WARN: ignoring unsupported non-empty interface type error field=E package=%s pos=%s:8:2 struct=Foo", pkg.Path, path))
+	assertStruct(t, foo, "Foo", "A", "B", "F")
+	require.Contains(buf.String(), fmt.Sprintf("WARN: ignoring unsupported type func() error package=%s pos=%s:12:6 type=Handler", pkg.Path, path))
+}
+
+func TestScannerStructKeyedMap(t *testing.T) {
+	require := require.New(t)
+
+	buf := captureReports(t)
+	pkg := scanSource(t, map[string]string{"foo.go": `package foo
+
+type Foo struct {
+	A int
+	M map[struct{ X int }]int
+}
+`})
+
+	assertStruct(t, pkg.Structs[0], "Foo", "A")
+	require.Contains(buf.String(), "ERROR: field will be ignored because map key type struct{X int} is a struct")
+	require.Contains(buf.String(), "field=M")
+	require.Contains(buf.String(), "struct=Foo")
+	require.NotContains(buf.String(), "WARN", "the field should only be reported once")
+}
+
+func TestScannerUnsupportedPolicy(t *testing.T) {
+	require := require.New(t)
+
+	dir := writeSource(t, map[string]string{"foo.go": `package foo
+
+import "unsafe"
+
+type Handler func() error
+
+type Foo struct {
+	A    int
+	Done chan struct{}
+	Fn   Handler
+	Ptr  unsafe.Pointer
+	Fns  map[string][]func()
+}
+`})
+
+	scan := func(policy UnsupportedPolicy) (*Package, error) {
+		scanner, err := New(dir)
+		require.Nil(err)
+		scanner.Unsupported = policy
+		pkgs, err := scanner.Scan()
+		if err != nil {
+			return nil, err
+		}
+		return pkgs[0], nil
+	}
+
+	pkg, err := scan(SkipUnsupported)
+	require.Nil(err)
+	assertStruct(t, pkg.Structs[0], "Foo", "A")
+	require.Nil(pkg.Structs[0].Omitted)
+
+	pkg, err = scan(OmitUnsupported)
+	require.Nil(err)
+	assertStruct(t, pkg.Structs[0], "Foo", "A")
+	require.Equal([]string{"Done", "Fn", "Ptr", "Fns"}, pkg.Structs[0].Omitted)
+
+	_, err = scan(RejectUnsupported)
+	require.True(errors.Is(err, ErrUnsupported))
+	require.Contains(err.Error(), "field Foo.Done has type chan struct{}, which can not be serialized")
+	require.Contains(err.Error(), "field Foo.Ptr has type unsafe.Pointer, which can not be serialized")
+}
+
+func TestScannerGenericInstances(t *testing.T) {
+	require := require.New(t)
+
+	pkg := scanSource(t, map[string]string{"foo.go": `package foo
+
+type List[T any] struct {
+	Items []T
+	Total int
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type Set[T comparable] []T
+
+type User struct {
+	Name string
+}
+
+type Page struct {
+	List[User]
+	Next string
+}
+
+type Index struct {
+	Users   *List[*User]
+	Entries []Pair[string, List[User]]
+	Tags    Set[string]
+}
+`})
+
+	dir := pkg.Path
+	var names []string
+	for _, s := range pkg.Structs {
+		names = append(names, s.Name)
+	}
+	require.Equal([]string{"ListUser", "PairStringListUser", "Index", "Page", "User"}, names)
+
+	assertStruct(t, pkg.Structs[0], "ListUser", "Items", "Total")
+	assertStruct(t, pkg.Structs[1], "PairStringListUser", "Key", "Value")
+
+	assertStruct(t, pkg.Structs[2], "Index", "Users", "Entries", "Tags")
+	require.Equal(NewNamed(dir, "ListUser"), pkg.Structs[2].Fields[0].Type)
+	require.Equal(repeated(NewNamed(dir, "PairStringListUser")), pkg.Structs[2].Fields[1].Type)
+	require.Equal(NewNamed(dir, "SetString"), pkg.Structs[2].Fields[2].Type)
+
+	assertStruct(t, pkg.Structs[3], "Page", "Items", "Total", "Next")
+
+	require.Equal(repeated(NewBasic("string")), pkg.Aliases[dir+".SetString"])
+	_, ok := pkg.Aliases[dir+".Set"]
+	require.False(ok, "generic types should not be aliases")
+}
+
+func TestScannerGenericInstanceNameClash(t *testing.T) {
+	require := require.New(t)
+
+	buf := captureReports(t)
+	pkg := scanSource(t, map[string]string{"foo.go": `package foo
+
+import "image"
+
+type List[T any] struct {
+	Items []T
+}
+
+type Point struct {
+	X, Y int
+}
+
+type User struct {
+	Name string
+}
+
+type ListUser struct {
+	Users []User
+}
+
+type Foo struct {
+	A List[Point]
+	B List[image.Point]
+	C List[Point]
+	D List[User]
+}
+`})
+
+	var names []string
+	for _, s := range pkg.Structs {
+		names = append(names, s.Name)
+	}
+	require.Equal([]string{"ListPoint", "Foo", "ListUser", "Point", "User"}, names)
+
+	foo := pkg.Structs[1]
+	assertStruct(t, foo, "Foo", "A", "C")
+	require.Equal(NewNamed(pkg.Path, "ListPoint"), foo.Fields[0].Type)
+	require.Equal(NewNamed(pkg.Path, "ListPoint"), foo.Fields[1].Type)
+	require.Equal(repeated(NewNamed(pkg.Path, "Point")), pkg.Structs[0].Fields[0].Type)
+
+	require.Contains(buf.String(), "ERROR: field will be ignored because "+pkg.Path+".List[image.Point] can not be converted to the message ListPoint, it is already used for "+pkg.Path+".List["+pkg.Path+".Point]")
+	require.Contains(buf.String(), "ERROR: field will be ignored because "+pkg.Path+".List["+pkg.Path+".User] can not be converted to the message ListUser, there is already a type with that name")
+	require.Contains(buf.String(), "field=D")
+}
+
+func TestScannerGenericInstancesOfBasicAliases(t *testing.T) {
+	require := require.New(t)
+
+	pkg := scanSource(t, map[string]string{"foo.go": `package foo
+
+type Box[T any] struct {
+	Value T
+}
+
+type Foo struct {
+	A Box[[]byte]
+	B Box[[]uint8]
+	C Box[rune]
+	D Box[int32]
+}
+`})
+
+	var names []string
+	for _, s := range pkg.Structs {
+		names = append(names, s.Name)
+	}
+	require.Equal([]string{"BoxUint8List", "BoxInt32", "Foo"}, names)
+
+	foo := pkg.Structs[2]
+	assertStruct(t, foo, "Foo", "A", "B", "C", "D")
+	require.Equal(NewNamed(pkg.Path, "BoxUint8List"), foo.Fields[1].Type)
+	require.Equal(NewNamed(pkg.Path, "BoxInt32"), foo.Fields[3].Type)
+}
+
+func TestScannerEnumValues(t *testing.T) {
+	require := require.New(t)
+
+	pkg := scanSource(t, map[string]string{"foo.go": `package foo
+
+type Size int
+
+const (
+	_ Size = iota
+	Small
+	_
+	Large
+)
+
+type Flag uint
+
+const (
+	FlagB Flag = 1 << (iota + 1)
+	FlagA
+	FlagZero Flag = 0
+)
+
+type Level int8
+
+const (
+	Low  Level = -1
+	High Level = 1
+)
+
+var NotAValue Size = 10
+`})
+
+	enums := pkg.Enums
+	require.Equal(3, len(enums))
+	require.Equal("int8", enums[1].Underlying, "Level underlying type")
+
+	values := make(map[string][]string)
+	for _, e := range enums {
+		for _, v := range e.Values {
+			values[e.Name] = append(values[e.Name], fmt.Sprintf("%s=%s", v.Name, v.Value))
+		}
+	}
+
+	require.Equal(map[string][]string{
+		"Size":  {"Small=1", "Large=3"},
+		"Flag":  {"FlagZero=0", "FlagB=2", "FlagA=4"},
+		"Level": {"Low=-1", "High=1"},
+	}, values)
+}
+
+func TestScannerEnumsAcrossFiles(t *testing.T) {
+	require := require.New(t)
+
+	pkg := scanSource(t, map[string]string{
+		"a.go": `package foo
+
+type Status int
+
+const (
+	Pending Status = iota
+	Running
+)
+
+const Failed Status = 10
+
+type Kind string
+`,
+		"b.go": `package foo
+
+const Done Status = 2
+
+const (
+	KindB Kind = "b"
+	KindA Kind = "a"
+)
+`,
+	})
+
+	var enums []string
+	for _, e := range pkg.Enums {
+		var names []string
+		for _, v := range e.Values {
+			names = append(names, v.Name)
+		}
+		enums = append(enums, fmt.Sprintf("%s%v", e.Name, names))
+	}
+
+	require.Equal([]string{
+		"Kind[KindA KindB]",
+		"Status[Pending Running Done Failed]",
+	}, enums)
+	require.True(pkg.Enums[0].IsString(), "Kind is a string enum")
+	require.Equal("int", pkg.Enums[1].Underlying)
+	require.False(pkg.Enums[1].IsString(), "Status is not a string enum")
+}
+
+func TestFindDirectives(t *testing.T) {
+	cg := &ast.CommentGroup{
+		List: []*ast.Comment{
+			{Text: "// Foo is a foo."},
+			{Text: "//proteus:ignore"},
+			{Text: "// proteus:notadirective"},
+			{Text: "//proteus:other with args"},
+		},
+	}
+
+	require.Equal(t, []*directive{
+		{"ignore", []string{}},
+		{"other", []string{"with", "args"}},
+	}, findDirectives(cg, nil))
+	require.True(t, hasDirective(ignoreDirective, nil, cg))
+	require.False(t, hasDirective("notadirective", cg))
+}
+
+func TestFindProtoPackage(t *testing.T) {
+	cases := []struct {
+		name     string
+		docs     []string
+		expected string
+		err      bool
+	}{
+		{"no directive", []string{"// Package foo does things."}, "", false},
+		{"directive", []string{"// Package foo.\n//proteus:package com.acme.foo.v1"}, "com.acme.foo.v1", false},
+		{"same in several files", []string{"//proteus:package foo", "//proteus:package foo"}, "foo", false},
+		{"conflicting", []string{"//proteus:package foo", "//proteus:package bar"}, "", true},
+		{"missing name", []string{"//proteus:package"}, "", true},
+		{"invalid name", []string{"//proteus:package foo-bar"}, "", true},
+	}
+
+	for _, c := range cases {
+		var files []*ast.File
+		for _, doc := range c.docs {
+			var comments []*ast.Comment
+			for _, line := range strings.Split(doc, "\n") {
+				comments = append(comments, &ast.Comment{Text: line})
+			}
+			files = append(files, &ast.File{Doc: &ast.CommentGroup{List: comments}})
+		}
+
+		pkg, err := findProtoPackage(files)
+		if c.err {
+			require.NotNil(t, err, c.name)
+		} else {
+			require.Nil(t, err, c.name)
+			require.Equal(t, c.expected, pkg, c.name)
+		}
+	}
 }
 
 func assertStruct(t *testing.T, s *Struct, name string, fields ...string) {
@@ -349,3 +1124,14 @@ func newNamed(path, name string, underlying types.Type) types.Type {
 	)
 	return types.NewNamed(obj, underlying, nil)
 }
+
+func BenchmarkScanner(b *testing.B) {
+	scanner, err := New(projectPath("fixtures"), projectPath("fixtures/subpkg"))
+	require.Nil(b, err)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := scanner.Scan()
+		require.Nil(b, err)
+	}
+}
@@ -18,3 +18,18 @@ func findProtoTags(tag string) []string {
 	}
 	return tags
 }
+
+// protoTypeOption is the option of the proto tag that forces the proto type
+// of a field, e.g. `proto:"type=uint64"`.
+const protoTypeOption = "type="
+
+// findProtoType returns the proto type forced in the given proto tags or an
+// empty string if none is.
+func findProtoType(tags []string) string {
+	for _, t := range tags {
+		if strings.HasPrefix(t, protoTypeOption) {
+			return strings.TrimSpace(strings.TrimPrefix(t, protoTypeOption))
+		}
+	}
+	return ""
+}